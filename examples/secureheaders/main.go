@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/carbocation/interpose"
+	"github.com/carbocation/interpose/middleware"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/graceful"
+)
+
+func main() {
+	mw := interpose.New()
+
+	// Set security headers, including a Content-Security-Policy that only
+	// permits inline scripts carrying this request's nonce.
+	// Must be called before the router because it modifies HTTP headers
+	mw.Use(middleware.SecureHeaders(middleware.SecureHeadersOptions{
+		HSTSMaxAge:         365 * 24 * time.Hour,
+		FrameOptions:       "DENY",
+		ContentTypeNosniff: true,
+		ReferrerPolicy:     "strict-origin-when-cross-origin",
+		ContentSecurityPolicy: middleware.NewCSP().
+			Add("default-src", "'self'").
+			Add("script-src", "'self'", middleware.CSPNonce),
+	}))
+
+	router := mux.NewRouter()
+	mw.UseHandler(router)
+
+	router.HandleFunc("/{user}", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `<h1>Welcome to the home page, %s!</h1><script nonce="%s">console.log("allowed")</script>`,
+			html.EscapeString(mux.Vars(req)["user"]), middleware.GetCSPNonce(req))
+	})
+
+	// Launch and permit graceful shutdown, allowing up to 10 seconds for existing
+	// connections to end
+	graceful.Run(":3001", 10*time.Second, mw)
+}
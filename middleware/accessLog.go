@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessLogOptions configures the AccessLog middleware.
+type AccessLogOptions struct {
+	// Logger receives the records. Defaults to slog.Default().
+	Logger *slog.Logger
+
+	// Message is the message of each record. Defaults to "request".
+	Message string
+
+	// Route returns the route of the request, such as "/users/{id}", when
+	// the router has not recorded one with SetRoute. It sees the request as
+	// it was before routing.
+	Route func(*http.Request) string
+
+	// Level chooses the level of a record from the response status. By
+	// default 5xx responses are logged at Error, 4xx at Warn and the rest at
+	// Info.
+	Level func(status int) slog.Level
+
+	// SuccessSampling logs only one in every SuccessSampling 2xx responses.
+	// Zero or one logs all of them.
+	SuccessSampling int
+
+	// Headers lists request headers to include in the record.
+	Headers []string
+
+	// RedactQuery and RedactHeaders list query parameters and headers whose
+	// values are replaced by "REDACTED".
+	RedactQuery   []string
+	RedactHeaders []string
+}
+
+// AccessLog returns a Handler that emits one structured record per request
+// through log/slog. Later middleware can add attributes to the record with
+// AddLogAttrs; BasicAuth adds the authenticated user this way. RequestID and
+// RealIP, if used, must be added before AccessLog.
+func AccessLog(opts AccessLogOptions) func(http.Handler) http.Handler {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Message == "" {
+		opts.Message = "request"
+	}
+	if opts.Level == nil {
+		opts.Level = levelForStatus
+	}
+	redactQuery := make(map[string]bool, len(opts.RedactQuery))
+	for _, q := range opts.RedactQuery {
+		redactQuery[q] = true
+	}
+	redactHeaders := make(map[string]bool, len(opts.RedactHeaders))
+	for _, h := range opts.RedactHeaders {
+		redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	var successes atomic.Uint64
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			req = withRouteHolder(req)
+			sw := &statusWriter{wrappedWriter: wrappedWriter{res}}
+			extra := &logAttrs{}
+			next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), logAttrsKey, extra)))
+
+			status := sw.Status()
+			if status/100 == 2 && opts.SuccessSampling > 1 &&
+				(successes.Add(1)-1)%uint64(opts.SuccessSampling) != 0 {
+				return
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+			}
+			if req.URL.RawQuery != "" {
+				attrs = append(attrs, slog.String("query", redactedQuery(req.URL, redactQuery)))
+			}
+			if route := routeOf(req, opts.Route); route != "" {
+				attrs = append(attrs, slog.String("route", route))
+			}
+			attrs = append(attrs,
+				slog.Int("status", status),
+				slog.Int64("bytes", sw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_ip", ClientIP(req)),
+				slog.String("user_agent", req.UserAgent()),
+			)
+			if id := GetRequestID(req); id != "" {
+				attrs = append(attrs, slog.String("request_id", id))
+			}
+			if len(opts.Headers) > 0 {
+				var headers []any
+				for _, name := range opts.Headers {
+					name = http.CanonicalHeaderKey(name)
+					if v := req.Header.Get(name); v != "" {
+						if redactHeaders[name] {
+							v = "REDACTED"
+						}
+						headers = append(headers, slog.String(name, v))
+					}
+				}
+				attrs = append(attrs, slog.Group("headers", headers...))
+			}
+			attrs = append(attrs, extra.get()...)
+
+			opts.Logger.LogAttrs(req.Context(), opts.Level(status), opts.Message, attrs...)
+		})
+	}
+}
+
+// AddLogAttrs adds attributes to the record that AccessLog emits for the
+// request and to the logger returned by GetLogger. It does nothing if neither
+// AccessLog nor RequestLogger is in use.
+func AddLogAttrs(req *http.Request, attrs ...slog.Attr) {
+	if extra, ok := req.Context().Value(logAttrsKey).(*logAttrs); ok {
+		extra.add(attrs...)
+	}
+	if l, ok := req.Context().Value(loggerKey).(*requestLogger); ok {
+		l.with(attrs...)
+	}
+}
+
+// logAttrs collects the attributes added by handlers nested inside AccessLog.
+type logAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (l *logAttrs) add(attrs ...slog.Attr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attrs = append(l.attrs, attrs...)
+}
+
+func (l *logAttrs) get() []slog.Attr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attrs
+}
+
+func levelForStatus(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+func redactedQuery(u *url.URL, redact map[string]bool) string {
+	if len(redact) == 0 {
+		return u.RawQuery
+	}
+	q := u.Query()
+	for k := range q {
+		if redact[k] {
+			for i := range q[k] {
+				q[k][i] = "REDACTED"
+			}
+		}
+	}
+	return q.Encode()
+}
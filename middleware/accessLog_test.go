@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func accessLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	i := interpose.New()
+	i.Use(AccessLog(AccessLogOptions{
+		Logger:        logger,
+		Headers:       []string{"Authorization", "X-Client"},
+		RedactQuery:   []string{"token"},
+		RedactHeaders: []string{"authorization"},
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SetRoute(req, "/users/{id}")
+		w.Write([]byte("hello"))
+	}))
+
+	req, _ := http.NewRequest("GET", "/users/1?token=secret&page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Client", "tests")
+	i.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := accessLogRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record but got %d", len(records))
+	}
+	record := records[0]
+	for k, want := range map[string]any{
+		"level":  "INFO",
+		"path":   "/users/1",
+		"route":  "/users/{id}",
+		"query":  "page=2&token=REDACTED",
+		"status": float64(200),
+		"bytes":  float64(5),
+	} {
+		if record[k] != want {
+			t.Errorf("Expected %s to be %v but got %v", k, want, record[k])
+		}
+	}
+	headers, _ := record["headers"].(map[string]any)
+	if headers["Authorization"] != "REDACTED" {
+		t.Errorf("Expected the Authorization header to be redacted but got %v", headers["Authorization"])
+	}
+	if headers["X-Client"] != "tests" {
+		t.Errorf("Expected the X-Client header to be logged but got %v", headers["X-Client"])
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("Expected no secret in the log")
+	}
+}
+
+func Test_AccessLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	i := interpose.New()
+	i.Use(AccessLog(AccessLogOptions{Logger: logger}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			http.NotFound(w, req)
+		case "/broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		case "/moved":
+			http.Redirect(w, req, "/", http.StatusFound)
+		}
+	}))
+
+	paths := []string{"/ok", "/moved", "/missing", "/broken"}
+	for _, path := range paths {
+		req, _ := http.NewRequest("GET", path, nil)
+		i.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	records := accessLogRecords(t, &buf)
+	if len(records) != len(paths) {
+		t.Fatalf("Expected %d records but got %d", len(paths), len(records))
+	}
+	for n, want := range []string{"INFO", "INFO", "WARN", "ERROR"} {
+		if records[n]["level"] != want {
+			t.Errorf("Expected %s to be logged at %s but got %v", paths[n], want, records[n]["level"])
+		}
+	}
+}
+
+func Test_AccessLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	i := interpose.New()
+	i.Use(AccessLog(AccessLogOptions{Logger: logger, SuccessSampling: 3}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+		}
+	}))
+
+	for n := 0; n < 6; n++ {
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		i.ServeHTTP(httptest.NewRecorder(), req)
+		req, _ = http.NewRequest("GET", "/missing", nil)
+		i.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var ok, missing int
+	for _, record := range accessLogRecords(t, &buf) {
+		if record["status"] == float64(200) {
+			ok++
+		} else {
+			missing++
+		}
+	}
+	if ok != 2 {
+		t.Errorf("Expected 2 of 6 successes to be logged but got %d", ok)
+	}
+	if missing != 6 {
+		t.Errorf("Expected every error to be logged but got %d", missing)
+	}
+}
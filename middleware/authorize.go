@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Principal is the authenticated subject that authorization rules are
+// evaluated against.
+type Principal struct {
+	Name   string
+	Roles  []string
+	Scopes []string
+}
+
+// HasRole reports whether the principal has the named role.
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && contains(p.Roles, role)
+}
+
+// HasScope reports whether the principal was granted the named scope.
+func (p *Principal) HasScope(scope string) bool {
+	return p != nil && contains(p.Scopes, scope)
+}
+
+// Rule is a single authorization policy. A request matches the rule if its
+// path matches Pattern and its method is one of Methods.
+type Rule struct {
+	// Pattern is matched against the request path with path.Match. A
+	// trailing "/**" matches the prefix and everything beneath it.
+	Pattern string
+
+	// Methods restricts the rule to the given methods. Empty matches all.
+	Methods []string
+
+	// Roles lists the roles that are granted access; the principal needs any
+	// one of them.
+	Roles []string
+
+	// Scopes lists the scopes that the principal must all hold.
+	Scopes []string
+
+	// Allow, if set, is consulted after Roles and Scopes and must also
+	// return true. It receives a nil principal for anonymous requests.
+	Allow func(*http.Request, *Principal) bool
+}
+
+// AuthorizeOptions configures the Authorize middleware.
+type AuthorizeOptions struct {
+	// Rules are evaluated in order and the first matching rule decides.
+	Rules []Rule
+
+	// DefaultAllow permits requests that match no rule. By default they are
+	// rejected.
+	DefaultAllow bool
+
+	// Principal looks up the principal of the request. Defaults to the
+	// Principal stored with SetPrincipal or, failing that, a Principal with
+	// no roles named after the User stored by BasicAuth.
+	Principal func(*http.Request) *Principal
+
+	// ForbiddenBody is the body written with the http.StatusForbidden
+	// response. Defaults to "Forbidden".
+	ForbiddenBody string
+
+	// Forbidden, if set, is called instead of writing ForbiddenBody.
+	Forbidden http.Handler
+}
+
+// Authorize returns a Handler that evaluates declarative rules against the
+// principal of the request and writes a http.StatusForbidden if access is
+// denied. It should be added after the middleware that authenticates the
+// request.
+func Authorize(opts AuthorizeOptions) func(http.Handler) http.Handler {
+	if opts.Principal == nil {
+		opts.Principal = defaultPrincipal
+	}
+	if opts.ForbiddenBody == "" {
+		opts.ForbiddenBody = "Forbidden"
+	}
+	if opts.Forbidden == nil {
+		opts.Forbidden = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, opts.ForbiddenBody, http.StatusForbidden)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if !opts.authorized(req) {
+				opts.Forbidden.ServeHTTP(res, req)
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// SetPrincipal returns a shallow copy of req carrying p, for use by
+// authentication middleware that know more about the caller than a User.
+func SetPrincipal(req *http.Request, p *Principal) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey, p))
+}
+
+// GetPrincipal returns the Principal stored with SetPrincipal, if any.
+func GetPrincipal(req *http.Request) (*Principal, bool) {
+	p, ok := req.Context().Value(principalKey).(*Principal)
+	return p, ok
+}
+
+func defaultPrincipal(req *http.Request) *Principal {
+	if p, ok := GetPrincipal(req); ok {
+		return p
+	}
+	if user, ok := GetUser(req); ok {
+		return &Principal{Name: string(user)}
+	}
+	return nil
+}
+
+func (opts *AuthorizeOptions) authorized(req *http.Request) bool {
+	for _, rule := range opts.Rules {
+		if !rule.matches(req) {
+			continue
+		}
+		return rule.permits(req, opts.Principal(req))
+	}
+	return opts.DefaultAllow
+}
+
+func (rule *Rule) matches(req *http.Request) bool {
+	if len(rule.Methods) > 0 && !contains(rule.Methods, req.Method) {
+		return false
+	}
+	return matchPath(rule.Pattern, req.URL.Path)
+}
+
+func (rule *Rule) permits(req *http.Request, p *Principal) bool {
+	if len(rule.Roles) > 0 || len(rule.Scopes) > 0 {
+		if p == nil {
+			return false
+		}
+	}
+	if len(rule.Roles) > 0 {
+		granted := false
+		for _, role := range rule.Roles {
+			if p.HasRole(role) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	for _, scope := range rule.Scopes {
+		if !p.HasScope(scope) {
+			return false
+		}
+	}
+	if rule.Allow != nil {
+		return rule.Allow(req, p)
+	}
+	return true
+}
+
+// matchPath reports whether the request path p matches pattern. Patterns use
+// path.Match syntax, and a trailing "/**" matches the prefix and everything
+// beneath it. p is cleaned first, as a router would, so that "/public/.."
+// or "//admin" cannot sidestep a rule.
+func matchPath(pattern, p string) bool {
+	p = cleanPath(p)
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+		if ok, _ := path.Match(prefix, p); ok {
+			return true
+		}
+		for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(prefix, dir); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// cleanPath returns the canonical form of the request path p, resolving
+// "." and ".." elements and repeated slashes but keeping a trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+var matchtests = []struct {
+	pattern string
+	path    string
+	val     bool
+}{
+	{"/admin", "/admin", true},
+	{"/admin", "/admin/users", false},
+	{"/admin/**", "/admin", true},
+	{"/admin/**", "/admin/users/1", true},
+	{"/admin/**", "/administrator", false},
+	{"/api/*/items", "/api/v1/items", true},
+	{"/api/*/**", "/api/v1/items/2", true},
+	{"/**", "/anything", true},
+	{"/public/**", "/public/../admin", false},
+	{"/admin/**", "/public/../admin/users", true},
+	{"/admin/**", "//admin/users", true},
+	{"/admin/**", "/admin/./users/", true},
+	{"/admin", "/admin/", false},
+}
+
+func Test_cleanPath(t *testing.T) {
+	for _, tt := range []struct{ path, want string }{
+		{"", "/"},
+		{"/", "/"},
+		{"admin", "/admin"},
+		{"//admin//users", "/admin/users"},
+		{"/public/../admin/", "/admin/"},
+		{"/../..", "/"},
+	} {
+		if got := cleanPath(tt.path); got != tt.want {
+			t.Errorf("Expected cleanPath(%q) to return %q but got %q", tt.path, tt.want, got)
+		}
+	}
+}
+
+func Test_matchPath(t *testing.T) {
+	for _, tt := range matchtests {
+		if matchPath(tt.pattern, tt.path) != tt.val {
+			t.Errorf("Expected matchPath(%v, %v) to return %v but did not", tt.pattern, tt.path, tt.val)
+		}
+	}
+}
+
+func Test_Authorize(t *testing.T) {
+	i := interpose.New()
+	i.Use(BasicAuthFunc(func(username, password string, _ *http.Request) bool {
+		return password == "spam"
+	}))
+	i.Use(Authorize(AuthorizeOptions{
+		Principal: func(req *http.Request) *Principal {
+			user, _ := GetUser(req)
+			if user == "root" {
+				return &Principal{Name: "root", Roles: []string{"admin"}}
+			}
+			return &Principal{Name: string(user)}
+		},
+		ForbiddenBody: "nope",
+		Rules: []Rule{
+			{Pattern: "/admin/**", Roles: []string{"admin"}},
+			{Pattern: "/public/**", Methods: []string{"GET"}},
+		},
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	for _, tt := range []struct {
+		user   string
+		method string
+		path   string
+		code   int
+	}{
+		{"root", "GET", "/admin/users", 200},
+		{"foo", "GET", "/admin/users", 403},
+		{"foo", "GET", "/public/page", 200},
+		{"foo", "POST", "/public/page", 403},
+		{"root", "GET", "/elsewhere", 403},
+		{"foo", "GET", "/public/../admin/users", 403},
+		{"foo", "GET", "//admin/users", 403},
+		{"foo", "GET", "/public//page", 200},
+	} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.user+":spam")))
+		i.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s %s as %s: got %d wanted %d", tt.method, tt.path, tt.user, recorder.Code, tt.code)
+		}
+		if tt.code == 403 && recorder.Body.String() != "nope\n" {
+			t.Errorf("unexpected forbidden body %q", recorder.Body.String())
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"log/slog"
 	"net/http"
 	"strings"
 )
@@ -15,7 +17,7 @@ type User string
 var BasicRealm = "Authorization Required"
 
 // Basic returns a Handler that authenticates via Basic Auth. Writes a http.StatusUnauthorized
-// if authentication fails
+// if authentication fails. The authenticated User is stored in the request context.
 func BasicAuth(username string, password string) func(http.Handler) http.Handler {
 	var siteAuth = base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
 	return func(next http.Handler) http.Handler {
@@ -25,13 +27,14 @@ func BasicAuth(username string, password string) func(http.Handler) http.Handler
 				unauthorized(res)
 				return
 			}
-			next.ServeHTTP(res, req)
+			next.ServeHTTP(res, withUser(req, User(username)))
 		})
 	}
 }
 
 // BasicAuthFunc returns a Handler that authenticates via Basic Auth using the provided function.
-// The function should return true for a valid username/password combination.
+// The function should return true for a valid username/password combination. The
+// authenticated User is stored in the request context.
 func BasicAuthFunc(authfn func(string, string, *http.Request) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
@@ -50,11 +53,22 @@ func BasicAuthFunc(authfn func(string, string, *http.Request) bool) func(http.Ha
 				unauthorized(res)
 				return
 			}
-			next.ServeHTTP(res, req)
+			next.ServeHTTP(res, withUser(req, User(tokens[0])))
 		})
 	}
 }
 
+// GetUser returns the User stored by BasicAuth or BasicAuthFunc, if any.
+func GetUser(req *http.Request) (User, bool) {
+	user, ok := req.Context().Value(userKey).(User)
+	return user, ok
+}
+
+func withUser(req *http.Request, user User) *http.Request {
+	AddLogAttrs(req, slog.String("user", string(user)))
+	return req.WithContext(context.WithValue(req.Context(), userKey, user))
+}
+
 // SecureCompare performs a constant time compare of two strings to limit timing attacks.
 func SecureCompare(given string, actual string) bool {
 	givenSha := sha256.Sum256([]byte(given))
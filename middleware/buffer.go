@@ -1,25 +1,280 @@
 package middleware
 
 import (
+	"bytes"
+	"io"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BufferOverflow selects what BufferWithOptions does with a response that
+// grows past MaxSize.
+type BufferOverflow int
 
-	"github.com/goods/httpbuf"
+const (
+	// BufferPassthrough sends what has been buffered and streams the rest
+	// of the response.
+	BufferPassthrough BufferOverflow = iota
+	// BufferTempFile keeps buffering the response in a temporary file.
+	BufferTempFile
 )
 
+// BufferOptions configures BufferWithOptions.
+type BufferOptions struct {
+	// MaxSize is the most bytes held in memory. Zero means no limit.
+	MaxSize int64
+
+	// Overflow chooses what happens past MaxSize. Defaults to
+	// BufferPassthrough.
+	Overflow BufferOverflow
+
+	// TempDir is the directory of the temporary files used by
+	// BufferTempFile. Defaults to os.TempDir().
+	TempDir string
+
+	// OnError, if set, is called when the response cannot be buffered or
+	// sent.
+	OnError func(*http.Request, error)
+}
+
 /*
 Middleware that buffers all http output. This permits
 output to be written before headers are sent. Downside:
 no output is sent until it's all ready to be sent, so
 this breaks streaming.
-
-Note: currently ignores errors
 */
 func Buffer() func(http.Handler) http.Handler {
+	return BufferWithOptions(BufferOptions{})
+}
+
+// BufferWithOptions is Buffer with a limit on memory use and a hook for
+// errors, which Buffer ignores.
+func BufferWithOptions(opts BufferOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bw := new(httpbuf.Buffer)
-			next.ServeHTTP(bw, r)
-			bw.Apply(w)
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			bw := newBufferWriter(res, req, &opts)
+			defer bw.release()
+			next.ServeHTTP(bw, req)
+			bw.apply()
 		})
 	}
 }
+
+// isUpgrade reports whether req asks to switch protocols, as WebSocket
+// handshakes do. The handler then needs to hijack the connection, so
+// middleware that buffer responses pass such requests through.
+func isUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the capacity above which buffers are not reused, so
+// that one large response does not pin its memory.
+const maxPooledBuffer = 1 << 20
+
+// bufferWriter holds the status, header and body of a response until apply
+// sends them. Middleware built on it, such as ETag, inspect and rewrite the
+// response in between.
+type bufferWriter struct {
+	res  http.ResponseWriter
+	req  *http.Request
+	opts *BufferOptions
+
+	header http.Header
+	status int
+	buf    *bytes.Buffer
+	file   *os.File
+	size   int64
+
+	// streaming is set once an overflow has sent the header, after which
+	// writes go straight to res.
+	streaming bool
+	failed    bool
+}
+
+func newBufferWriter(res http.ResponseWriter, req *http.Request, opts *BufferOptions) *bufferWriter {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return &bufferWriter{
+		res:    res,
+		req:    req,
+		opts:   opts,
+		header: res.Header().Clone(),
+		buf:    buf,
+	}
+}
+
+func (w *bufferWriter) Header() http.Header {
+	if w.streaming {
+		return w.res.Header()
+	}
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.streaming {
+		n, err := w.res.Write(b)
+		w.size += int64(n)
+		if err != nil {
+			w.fail(err)
+		}
+		return n, err
+	}
+	if w.file != nil {
+		n, err := w.file.Write(b)
+		w.size += int64(n)
+		if err != nil {
+			w.fail(err)
+		}
+		return n, err
+	}
+
+	if w.opts.MaxSize > 0 && int64(w.buf.Len()+len(b)) > w.opts.MaxSize {
+		if w.opts.Overflow == BufferTempFile {
+			if err := w.spill(); err == nil {
+				return w.Write(b)
+			}
+		}
+		w.streaming = true
+		w.send()
+		return w.Write(b)
+	}
+	w.size += int64(len(b))
+	return w.buf.Write(b)
+}
+
+// spill moves the buffered body to a temporary file.
+func (w *bufferWriter) spill() error {
+	f, err := os.CreateTemp(w.opts.TempDir, "interpose-buffer-")
+	if err == nil {
+		_, err = w.buf.WriteTo(f)
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		w.fail(err)
+		return err
+	}
+	w.file = f
+	return nil
+}
+
+// Status returns the status code of the response, which is http.StatusOK
+// if the handler never called WriteHeader.
+func (w *bufferWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Len returns the size of the body written so far.
+func (w *bufferWriter) Len() int64 {
+	return w.size
+}
+
+// Bytes returns the buffered body, or nil if it is not held in memory.
+func (w *bufferWriter) Bytes() []byte {
+	if w.streaming || w.file != nil {
+		return nil
+	}
+	return w.buf.Bytes()
+}
+
+// Buffered reports whether the whole response is still held back.
+func (w *bufferWriter) Buffered() bool {
+	return !w.streaming
+}
+
+// Reset discards the buffered body, so that middleware can replace it.
+func (w *bufferWriter) Reset() {
+	if w.file != nil {
+		w.file.Close()
+		os.Remove(w.file.Name())
+		w.file = nil
+	}
+	w.buf.Reset()
+	w.size = 0
+}
+
+// send writes the header and the buffered body to res.
+func (w *bufferWriter) send() {
+	dst := w.res.Header()
+	for k := range dst {
+		if _, ok := w.header[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	w.res.WriteHeader(w.Status())
+
+	var err error
+	if w.file != nil {
+		if _, err = w.file.Seek(0, io.SeekStart); err == nil {
+			_, err = io.Copy(w.res, w.file)
+		}
+	} else {
+		_, err = w.res.Write(w.buf.Bytes())
+	}
+	if err != nil {
+		w.fail(err)
+	}
+}
+
+// apply sends the response unless it has already started streaming.
+func (w *bufferWriter) apply() {
+	if !w.streaming {
+		w.send()
+	}
+}
+
+// release returns the memory buffer to the pool and removes any temporary
+// file.
+func (w *bufferWriter) release() {
+	if w.file != nil {
+		w.file.Close()
+		os.Remove(w.file.Name())
+		w.file = nil
+	}
+	if w.buf != nil && w.buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(w.buf)
+	}
+	w.buf = nil
+}
+
+// fail reports the first error of the response.
+func (w *bufferWriter) fail(err error) {
+	if w.failed {
+		return
+	}
+	w.failed = true
+	if w.opts.OnError != nil {
+		w.opts.OnError(w.req, err)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_Buffer(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	i := interpose.New()
+	i.Use(Buffer())
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "hello")
+		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("X-Late", "yes")
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status 200 from the first write but got %d", recorder.Code)
+	}
+	if recorder.Header().Get("X-Late") != "yes" {
+		t.Error("Expected a header set after the body to be sent")
+	}
+	if recorder.Body.String() != "hello" {
+		t.Errorf("Expected body hello but got %q", recorder.Body.String())
+	}
+}
+
+func Test_BufferOverflow(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	for _, overflow := range []BufferOverflow{BufferPassthrough, BufferTempFile} {
+		recorder := httptest.NewRecorder()
+
+		i := interpose.New()
+		i.Use(BufferWithOptions(BufferOptions{
+			MaxSize:  10,
+			Overflow: overflow,
+			TempDir:  t.TempDir(),
+			OnError:  func(req *http.Request, err error) { t.Error(err) },
+		}))
+		i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			for _, c := range body {
+				fmt.Fprint(w, string(c))
+			}
+		}))
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusAccepted || recorder.Body.String() != body {
+			t.Errorf("Overflow %d: expected status 202 and the full body but got %d %q", overflow, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// RangeOptions configures the Range middleware.
+type RangeOptions struct {
+	// MaxSize is the largest body, in bytes, that is buffered to serve
+	// ranges from. Larger responses are streamed whole. Defaults to 10MB.
+	MaxSize int64
+
+	// MaxRanges is the most ranges served in one response. Requests for
+	// more, or for ranges that add up to more than the body, are answered
+	// with the whole body. Defaults to 16.
+	MaxRanges int
+
+	// OnError, if set, is called when the response cannot be sent.
+	OnError func(*http.Request, error)
+}
+
+// Range returns a Handler that buffers GET responses and answers Range
+// requests for 200 responses with 206 Partial Content, using a
+// multipart/byteranges body for several ranges, or 416 Range Not
+// Satisfiable. If-Range is honored against the ETag and Last-Modified of
+// the response, so ETag, if used, should come after Range. Protocol
+// upgrades are passed through unbuffered.
+func Range(opts RangeOptions) func(http.Handler) http.Handler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+	if opts.MaxRanges <= 0 {
+		opts.MaxRanges = 16
+	}
+	bopts := &BufferOptions{MaxSize: opts.MaxSize, OnError: opts.OnError}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.Method != "GET" || isUpgrade(req) {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			bw := newBufferWriter(res, req, bopts)
+			defer bw.release()
+			next.ServeHTTP(bw, req)
+
+			body := bw.Bytes()
+			if body == nil || bw.Status() != http.StatusOK {
+				bw.apply()
+				return
+			}
+			h := bw.Header()
+			if h.Get("Accept-Ranges") == "" {
+				h.Set("Accept-Ranges", "bytes")
+			}
+
+			header := req.Header.Get("Range")
+			if header == "" || !ifRange(req, h) {
+				bw.apply()
+				return
+			}
+			size := int64(len(body))
+			ranges, ok := parseRange(header, size)
+			if !ok || len(ranges) > opts.MaxRanges || rangesSize(ranges) > size {
+				bw.apply()
+				return
+			}
+			if len(ranges) == 0 {
+				bw.Reset()
+				bw.status = http.StatusRequestedRangeNotSatisfiable
+				h.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+				h.Del("Content-Type")
+				h.Del("Content-Length")
+				bw.apply()
+				return
+			}
+
+			var partial bytes.Buffer
+			if len(ranges) == 1 {
+				r := ranges[0]
+				h.Set("Content-Range", r.contentRange(size))
+				partial.Write(body[r.start : r.start+r.length])
+			} else {
+				mw := multipart.NewWriter(&partial)
+				contentType := h.Get("Content-Type")
+				for _, r := range ranges {
+					part := textproto.MIMEHeader{"Content-Range": {r.contentRange(size)}}
+					if contentType != "" {
+						part.Set("Content-Type", contentType)
+					}
+					pw, _ := mw.CreatePart(part)
+					pw.Write(body[r.start : r.start+r.length])
+				}
+				mw.Close()
+				h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+			}
+
+			bw.Reset()
+			bw.status = http.StatusPartialContent
+			h.Set("Content-Length", strconv.Itoa(partial.Len()))
+			bw.Write(partial.Bytes())
+			bw.apply()
+		})
+	}
+}
+
+// byteRange is a satisfiable range of a body.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// rangesSize returns the sum of the lengths of ranges. Overlapping ranges
+// can make it larger than the body, in which case sending the body whole is
+// cheaper, as http.ServeContent also does.
+func rangesSize(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+	return n
+}
+
+// parseRange parses a Range header for a body of the given size. It
+// returns ok false if the header is malformed or not in bytes, in which case
+// it must be ignored, and no ranges if none is satisfiable.
+func parseRange(header string, size int64) (ranges []byteRange, ok bool) {
+	specs, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, false
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, false
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// A suffix range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, false
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, false
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, true
+}
+
+// ifRange reports whether the Range header should be honored given the
+// If-Range header, which must match the ETag strongly or equal the
+// Last-Modified date.
+func ifRange(req *http.Request, h http.Header) bool {
+	value := req.Header.Get("If-Range")
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return etagMatch(value, h.Get("ETag"), true)
+	}
+	lm := h.Get("Last-Modified")
+	return lm != "" && lm == value
+}
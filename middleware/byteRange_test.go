@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_Range(t *testing.T) {
+	i := interpose.New()
+	i.Use(Range(RangeOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "0123456789")
+	}))
+
+	tests := []struct {
+		rng, ifRange string
+		code         int
+		body         string
+		contentRange string
+	}{
+		{"", "", 200, "0123456789", ""},
+		{"bytes=0-3", "", 206, "0123", "bytes 0-3/10"},
+		{"bytes=7-", "", 206, "789", "bytes 7-9/10"},
+		{"bytes=-2", "", 206, "89", "bytes 8-9/10"},
+		{"bytes=5-100", `"v1"`, 206, "56789", "bytes 5-9/10"},
+		{"bytes=5-100", `"v2"`, 200, "0123456789", ""},
+		{"bytes=10-", "", 416, "", "bytes */10"},
+		{"bytes=3-1", "", 200, "0123456789", ""},
+		{"bytes=0-,0-", "", 200, "0123456789", ""},
+		{"bytes=0-5,3-9", "", 200, "0123456789", ""},
+		{"bytes=-9,0-,-9", "", 200, "0123456789", ""},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		if tt.ifRange != "" {
+			req.Header.Set("If-Range", tt.ifRange)
+		}
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body || recorder.Header().Get("Content-Range") != tt.contentRange {
+			t.Errorf("Range %q: got %d %q %q, expected %d %q %q", tt.rng, recorder.Code, recorder.Body.String(),
+				recorder.Header().Get("Content-Range"), tt.code, tt.body, tt.contentRange)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// MaxSize is the most bytes of response bodies the cache holds. The
+	// least recently used responses are evicted past it. Defaults to 64MB.
+	MaxSize int64
+
+	// MaxEntrySize is the largest body, in bytes, that is cached. Defaults
+	// to 1MB.
+	MaxEntrySize int64
+
+	// DefaultTTL is how long responses without explicit freshness
+	// (max-age, s-maxage or Expires) are cached. Defaults to zero, which
+	// does not cache them.
+	DefaultTTL time.Duration
+
+	// Key returns the cache key of the request. Defaults to the method, a
+	// space, the host and the request URI, such as
+	// "GET example.com/users?page=2". Purge and PurgePrefix take keys in
+	// this form.
+	Key func(*http.Request) string
+
+	// OnError, if set, is called when a response cannot be sent.
+	OnError func(*http.Request, error)
+}
+
+// Cache is an in-memory HTTP cache, shared between clients, for GET and HEAD
+// responses. It follows the Cache-Control directives of responses (max-age,
+// s-maxage, no-store, no-cache, private and stale-while-revalidate) and of
+// requests (no-store and no-cache), keeps a variant per value of the
+// headers named in Vary, and sends one request upstream for concurrent
+// misses of the same key. Its Handler method is the middleware:
+//
+//	cache := middleware.NewCache(middleware.CacheOptions{})
+//	middle.Use(cache.Handler)
+type Cache struct {
+	opts  CacheOptions
+	bopts BufferOptions
+
+	mu       sync.Mutex
+	resp     map[string]*cachedResource
+	lru      *list.List
+	size     int64
+	inFlight map[string]*cacheCall
+}
+
+// cachedResource holds the variants of the responses for one key.
+type cachedResource struct {
+	vary     []string
+	variants map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key, variant string
+	vary         []string
+	status       int
+	header       http.Header
+	body         []byte
+	stored       time.Time
+	ttl          time.Duration
+	swr          time.Duration
+
+	revalidating bool
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return now.Sub(e.stored)
+}
+
+// cacheCall is an upstream request that concurrent misses wait for.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	// waiters counts the requests waiting on done.
+	waiters int
+}
+
+// NewCache returns an empty Cache.
+func NewCache(opts CacheOptions) *Cache {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 64 << 20
+	}
+	if opts.MaxEntrySize <= 0 {
+		opts.MaxEntrySize = 1 << 20
+	}
+	if opts.Key == nil {
+		opts.Key = func(req *http.Request) string {
+			return req.Method + " " + req.Host + req.URL.RequestURI()
+		}
+	}
+	return &Cache{
+		opts:     opts,
+		bopts:    BufferOptions{MaxSize: opts.MaxEntrySize, OnError: opts.OnError},
+		resp:     make(map[string]*cachedResource),
+		lru:      list.New(),
+		inFlight: make(map[string]*cacheCall),
+	}
+}
+
+// Handler serves GET and HEAD requests from the cache, passing misses on to
+// next and storing the responses that may be shared. Protocol upgrades are
+// passed through.
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if (req.Method != "GET" && req.Method != "HEAD") || isUpgrade(req) {
+			next.ServeHTTP(res, req)
+			return
+		}
+		reqCC := parseCacheControl(strings.Join(req.Header.Values("Cache-Control"), ","))
+		if _, ok := reqCC["no-store"]; ok {
+			next.ServeHTTP(res, req)
+			return
+		}
+		_, noCache := reqCC["no-cache"]
+		key := c.opts.Key(req)
+
+		if !noCache {
+			if entry, stale := c.lookup(key, req); entry != nil {
+				if stale {
+					c.revalidate(entry, next, req)
+				}
+				c.serve(res, req, entry)
+				return
+			}
+		}
+
+		// Collapse concurrent misses: the first request goes upstream and
+		// the others wait for its response, if it can be shared.
+		flightKey := key + "\x00" + c.variantOf(key, req)
+		c.mu.Lock()
+		if call, ok := c.inFlight[flightKey]; ok && !noCache {
+			call.waiters++
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-req.Context().Done():
+				return
+			}
+			// The variant was unknown before the first response, so the
+			// waiter may need a different one.
+			if call.entry != nil && call.entry.variant == variantKey(call.entry.vary, req) {
+				c.serve(res, req, call.entry)
+				return
+			}
+			c.fetch(res, req, next, key)
+			return
+		}
+		call := &cacheCall{done: make(chan struct{})}
+		c.inFlight[flightKey] = call
+		c.mu.Unlock()
+
+		defer func() {
+			c.mu.Lock()
+			delete(c.inFlight, flightKey)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+		call.entry = c.fetch(res, req, next, key)
+	})
+}
+
+// fetch serves the request from upstream and stores the response if it is
+// cacheable, returning the new entry.
+func (c *Cache) fetch(res http.ResponseWriter, req *http.Request, next http.Handler, key string) *cacheEntry {
+	base := res.Header().Clone()
+	bw := newBufferWriter(res, req, &c.bopts)
+	defer bw.release()
+	next.ServeHTTP(bw, req)
+
+	var entry *cacheEntry
+	if bw.Buffered() {
+		entry = c.store(key, req, bw, base)
+	}
+	bw.apply()
+	return entry
+}
+
+// revalidate refreshes a stale entry in the background, once.
+func (c *Cache) revalidate(entry *cacheEntry, next http.Handler, req *http.Request) {
+	c.mu.Lock()
+	if entry.revalidating {
+		c.mu.Unlock()
+		return
+	}
+	entry.revalidating = true
+	c.mu.Unlock()
+
+	r := req.Clone(context.WithoutCancel(req.Context()))
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			entry.revalidating = false
+			c.mu.Unlock()
+		}()
+		c.fetch(&discardWriter{header: make(http.Header)}, r, next, entry.key)
+	}()
+}
+
+// lookup returns the entry for the request, if it is fresh or may be served
+// stale while it is revalidated.
+func (c *Cache) lookup(key string, req *http.Request) (entry *cacheEntry, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.resp[key]
+	if !ok {
+		return nil, false
+	}
+	el, ok := r.variants[variantKey(r.vary, req)]
+	if !ok {
+		return nil, false
+	}
+	entry = el.Value.(*cacheEntry)
+	age := entry.age(time.Now())
+	if age > entry.ttl+entry.swr {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry, age > entry.ttl
+}
+
+// variantOf returns the variant key of the request among the known
+// variants of key.
+func (c *Cache) variantOf(key string, req *http.Request) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.resp[key]; ok {
+		return variantKey(r.vary, req)
+	}
+	return ""
+}
+
+// store caches the buffered response if it may be shared. Only the headers
+// that differ from base, the header of res before next ran, are stored, so
+// that headers set by outer middleware for one request are not replayed.
+func (c *Cache) store(key string, req *http.Request, bw *bufferWriter, base http.Header) *cacheEntry {
+	switch bw.Status() {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+	h := bw.Header()
+	cc := parseCacheControl(strings.Join(h.Values("Cache-Control"), ","))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return nil
+		}
+	}
+	_, public := cc["public"]
+	if req.Header.Get("Authorization") != "" && !public {
+		if _, ok := cc["s-maxage"]; !ok {
+			return nil
+		}
+	}
+	if h.Get("Set-Cookie") != "" {
+		return nil
+	}
+	vary := parseHeaderList(strings.Join(h.Values("Vary"), ","))
+	for i, v := range vary {
+		if v == "*" {
+			return nil
+		}
+		vary[i] = http.CanonicalHeaderKey(v)
+	}
+
+	ttl := freshness(cc, h, c.opts.DefaultTTL)
+	if ttl <= 0 {
+		return nil
+	}
+	entry := &cacheEntry{
+		key:     key,
+		variant: variantKey(vary, req),
+		vary:    vary,
+		status:  bw.Status(),
+		header:  handlerHeader(base, h),
+		body:    append([]byte(nil), bw.Bytes()...),
+		stored:  time.Now(),
+		ttl:     ttl,
+		swr:     directiveSeconds(cc, "stale-while-revalidate"),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.resp[key]
+	if !ok || !sameHeaders(r.vary, vary) {
+		if ok {
+			for _, el := range r.variants {
+				c.remove(el)
+			}
+		}
+		r = &cachedResource{vary: vary, variants: make(map[string]*list.Element)}
+		c.resp[key] = r
+	}
+	if old, ok := r.variants[entry.variant]; ok {
+		c.remove(old)
+	}
+	r.variants[entry.variant] = c.lru.PushFront(entry)
+	c.size += int64(len(entry.body))
+	for c.size > c.opts.MaxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return entry
+}
+
+// remove evicts an entry. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	c.size -= int64(len(entry.body))
+	if r, ok := c.resp[entry.key]; ok && r.variants[entry.variant] == el {
+		delete(r.variants, entry.variant)
+		if len(r.variants) == 0 {
+			delete(c.resp, entry.key)
+		}
+	}
+}
+
+// serve writes a cached response. Headers already set on res by outer
+// middleware are kept, and the cached Vary is merged into theirs.
+func (c *Cache) serve(res http.ResponseWriter, req *http.Request, entry *cacheEntry) {
+	h := res.Header()
+	for k, v := range entry.header {
+		if k == "Vary" {
+			for _, name := range parseHeaderList(strings.Join(v, ",")) {
+				addVary(h, name)
+			}
+			continue
+		}
+		if _, ok := h[k]; !ok {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+	h.Set("Age", strconv.FormatInt(int64(entry.age(time.Now())/time.Second), 10))
+	res.WriteHeader(entry.status)
+	if req.Method != "HEAD" {
+		if _, err := res.Write(entry.body); err != nil && c.opts.OnError != nil {
+			c.opts.OnError(req, err)
+		}
+	}
+}
+
+// Purge removes the responses stored under key, in all their variants.
+func (c *Cache) Purge(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.resp[key]; ok {
+		for _, el := range r.variants {
+			c.remove(el)
+		}
+	}
+}
+
+// PurgePrefix removes the responses whose keys start with prefix and
+// returns how many keys were purged.
+func (c *Cache) PurgePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, r := range c.resp {
+		if strings.HasPrefix(key, prefix) {
+			for _, el := range r.variants {
+				c.remove(el)
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// waiting returns the number of requests that joined upstream calls still in
+// flight instead of making their own.
+func (c *Cache) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, call := range c.inFlight {
+		n += call.waiters
+	}
+	return n
+}
+
+// Len returns the number of responses in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// variantKey joins the values of the request headers named in vary.
+func variantKey(vary []string, req *http.Request) string {
+	if len(vary) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range vary {
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func sameHeaders(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// handlerHeader returns the fields of h that differ from base.
+func handlerHeader(base, h http.Header) http.Header {
+	out := make(http.Header)
+	for k, v := range h {
+		if !sameHeaders(base[k], v) {
+			out[k] = append([]string(nil), v...)
+		}
+	}
+	return out
+}
+
+// freshness returns how long a response may be served from a shared cache.
+func freshness(cc map[string]string, h http.Header, defaultTTL time.Duration) time.Duration {
+	if _, ok := cc["s-maxage"]; ok {
+		return directiveSeconds(cc, "s-maxage")
+	}
+	if _, ok := cc["max-age"]; ok {
+		return directiveSeconds(cc, "max-age")
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return t.Sub(date)
+	}
+	return defaultTTL
+}
+
+func directiveSeconds(cc map[string]string, name string) time.Duration {
+	n, err := strconv.ParseInt(cc[name], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// parseCacheControl parses a Cache-Control header into lower-case
+// directives and their unquoted values.
+func parseCacheControl(value string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			cc[name] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return cc
+}
+
+// discardWriter is the ResponseWriter of background revalidations.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
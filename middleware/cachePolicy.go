@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// CacheRule sets the caching headers of the responses to requests whose
+// path matches Pattern. Patterns are path.Match patterns, and a trailing
+// "/**" matches a path and everything below it.
+type CacheRule struct {
+	Pattern string
+
+	// Authenticated restricts the rule to authenticated requests.
+	Authenticated bool
+
+	// CacheControl is the Cache-Control header, such as
+	// "public, max-age=31536000, immutable" or "no-store".
+	CacheControl string
+
+	// Expires, if set, sets the Expires header to this long after the
+	// response.
+	Expires time.Duration
+
+	// Vary lists request headers added to the Vary header.
+	Vary []string
+
+	// SurrogateControl is the Surrogate-Control header read by CDNs.
+	SurrogateControl string
+}
+
+// CachePolicyOptions configures the CachePolicy middleware.
+type CachePolicyOptions struct {
+	// Rules are tried in order; the first match applies.
+	Rules []CacheRule
+
+	// Authenticated reports whether a request is authenticated. Defaults
+	// to the request having an Authorization header, or a user or
+	// principal set by middleware added before CachePolicy.
+	Authenticated func(*http.Request) bool
+}
+
+// CachePolicy returns a Handler that sets Cache-Control, Expires, Vary and
+// Surrogate-Control according to the first matching rule. The headers are
+// set just before the response header is sent, and those the handler set
+// itself are kept. Only 2xx and 304 responses are affected, so that errors
+// and redirects are not cached for as long as the content.
+//
+//	middle.Use(middleware.CachePolicy(middleware.CachePolicyOptions{
+//		Rules: []middleware.CacheRule{
+//			{Pattern: "/static/**", CacheControl: "public, max-age=31536000, immutable"},
+//			{Pattern: "/api/**", CacheControl: "no-store"},
+//			{Pattern: "/**", Authenticated: true, CacheControl: "private, no-cache"},
+//		},
+//	}))
+func CachePolicy(opts CachePolicyOptions) func(http.Handler) http.Handler {
+	if opts.Authenticated == nil {
+		opts.Authenticated = func(req *http.Request) bool {
+			if req.Header.Get("Authorization") != "" {
+				return true
+			}
+			if _, ok := GetUser(req); ok {
+				return true
+			}
+			_, ok := GetPrincipal(req)
+			return ok
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			var rule *CacheRule
+			for i := range opts.Rules {
+				r := &opts.Rules[i]
+				if matchPath(r.Pattern, req.URL.Path) && (!r.Authenticated || opts.Authenticated(req)) {
+					rule = r
+					break
+				}
+			}
+			if rule == nil {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			pw := &policyWriter{wrappedWriter: wrappedWriter{res}, rule: rule}
+			next.ServeHTTP(pw, req)
+			pw.apply(http.StatusOK)
+		})
+	}
+}
+
+// policyWriter sets the headers of a CacheRule before the response header
+// is sent.
+type policyWriter struct {
+	wrappedWriter
+	rule    *CacheRule
+	applied bool
+}
+
+func (w *policyWriter) apply(status int) {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	if status/100 != 2 && status != http.StatusNotModified {
+		return
+	}
+	h := w.Header()
+	if w.rule.CacheControl != "" && h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", w.rule.CacheControl)
+	}
+	if w.rule.Expires != 0 && h.Get("Expires") == "" {
+		h.Set("Expires", time.Now().Add(w.rule.Expires).UTC().Format(http.TimeFormat))
+	}
+	for _, v := range w.rule.Vary {
+		addVary(h, v)
+	}
+	if w.rule.SurrogateControl != "" && h.Get("Surrogate-Control") == "" {
+		h.Set("Surrogate-Control", w.rule.SurrogateControl)
+	}
+}
+
+func (w *policyWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.apply(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *policyWriter) Write(b []byte) (int, error) {
+	w.apply(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *policyWriter) Flush() {
+	w.apply(http.StatusOK)
+	w.wrappedWriter.Flush()
+}
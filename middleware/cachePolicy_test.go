@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_CachePolicy(t *testing.T) {
+	i := interpose.New()
+	i.Use(CachePolicy(CachePolicyOptions{
+		Rules: []CacheRule{
+			{Pattern: "/static/**", CacheControl: "public, max-age=31536000, immutable", Expires: time.Hour, Vary: []string{"Accept-Encoding"}},
+			{Pattern: "/api/**", CacheControl: "no-store", SurrogateControl: "no-store"},
+			{Pattern: "/**", Authenticated: true, CacheControl: "private, no-cache"},
+		},
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/explicit":
+			w.Header().Set("Cache-Control", "max-age=5")
+		case "/static/missing.js":
+			http.NotFound(w, req)
+			return
+		case "/static/moved.js":
+			http.Redirect(w, req, "/static/app.js", http.StatusFound)
+			return
+		case "/static/cached.js":
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+
+	tests := []struct {
+		path, auth, cacheControl string
+	}{
+		{"/static/app.js", "", "public, max-age=31536000, immutable"},
+		{"/api/users", "", "no-store"},
+		{"/api/explicit", "", "max-age=5"},
+		{"/home", "Bearer x", "private, no-cache"},
+		{"/home", "", ""},
+		{"/static/../api/users", "", "no-store"},
+		{"/static/missing.js", "", ""},
+		{"/static/moved.js", "", ""},
+		{"/static/cached.js", "", "public, max-age=31536000, immutable"},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		i.ServeHTTP(recorder, req)
+
+		if got := recorder.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q but got %q", tt.path, tt.cacheControl, got)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/static/app.js", nil)
+	i.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Expires") == "" || recorder.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Expires and Vary to be set but got %v", recorder.Header())
+	}
+}
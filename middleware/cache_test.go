@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_Cache(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewCache(CacheOptions{})
+
+	i := interpose.New()
+	i.Use(cache.Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := calls.Add(1)
+		switch req.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/lang":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprintf(w, "%d %s", n, req.Header.Get("Accept-Language"))
+	}))
+
+	get := func(path, lang string) string {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		i.ServeHTTP(recorder, req)
+		return recorder.Body.String()
+	}
+
+	if a, b := get("/", ""), get("/", ""); a != b {
+		t.Errorf("Expected the second response to be cached but got %q then %q", a, b)
+	}
+	if a, b := get("/private", ""), get("/private", ""); a == b {
+		t.Error("Expected a private response not to be cached")
+	}
+	if en, fr := get("/lang", "en"), get("/lang", "fr"); en == fr {
+		t.Error("Expected a variant per Accept-Language")
+	}
+	if a, b := get("/lang", "en"), get("/lang", "en"); a != b {
+		t.Error("Expected the variant to be cached")
+	}
+
+	before := get("/", "")
+	cache.Purge("GET example.com/")
+	if get("/", "") == before {
+		t.Error("Expected Purge to remove the response")
+	}
+	if n := cache.PurgePrefix("GET example.com/lang"); n != 1 {
+		t.Errorf("Expected PurgePrefix to purge 1 key but purged %d", n)
+	}
+}
+
+// waitCacheWaiters waits until n requests are waiting on another's upstream
+// call.
+func waitCacheWaiters(t *testing.T, c *Cache, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.waiting() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting requests but got %d", n, c.waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_CacheOuterHeaders(t *testing.T) {
+	i := interpose.New()
+	i.Use(RequestID(RequestIDOptions{}))
+	i.Use(NewCache(CacheOptions{}).Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("X-Upstream", "yes")
+		fmt.Fprint(w, "hello")
+	}))
+
+	for _, id := range []string{"first", "second"} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-ID", id)
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, req)
+
+		if got := recorder.Header().Get("X-Request-ID"); got != id {
+			t.Errorf("Expected the request's own ID %q but got %q", id, got)
+		}
+		if recorder.Header().Get("X-Upstream") != "yes" || recorder.Body.String() != "hello" {
+			t.Errorf("Expected the upstream response but got %v %q", recorder.Header(), recorder.Body.String())
+		}
+	}
+}
+
+func Test_CacheCollapse(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := NewCache(CacheOptions{})
+
+	i := interpose.New()
+	i.Use(cache.Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "slow")
+	}))
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/", nil)
+			i.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	waitCacheWaiters(t, cache, 9)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected concurrent misses to make 1 upstream call but made %d", calls.Load())
+	}
+
+	// Requests waiting on a response for another Vary variant must not be
+	// served it.
+	entered := make(chan struct{}, 10)
+	release = make(chan struct{})
+	cache = NewCache(CacheOptions{})
+	i = interpose.New()
+	i.Use(cache.Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, req.Header.Get("Accept-Language"))
+	}))
+
+	languages := []string{"en", "fr", "fr", "de", "en"}
+	recorders := make([]*httptest.ResponseRecorder, len(languages))
+	for n, lang := range languages {
+		recorders[n] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(recorder *httptest.ResponseRecorder, lang string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Language", lang)
+			i.ServeHTTP(recorder, req)
+		}(recorders[n], lang)
+		if n == 0 {
+			<-entered
+		}
+	}
+	// Every request but the first waits on it; the fr and de ones are
+	// then fetched again.
+	waitCacheWaiters(t, cache, len(languages)-1)
+	close(release)
+	wg.Wait()
+
+	for n, lang := range languages {
+		if recorders[n].Body.String() != lang {
+			t.Errorf("Expected the %q variant but got %q", lang, recorders[n].Body.String())
+		}
+	}
+}
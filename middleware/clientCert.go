@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+// ClientIdentity describes the peer that authenticated with a TLS client
+// certificate.
+type ClientIdentity struct {
+	// Certificate is the leaf certificate presented by the client.
+	Certificate *x509.Certificate
+
+	// Chains are the verified chains from the leaf to a trusted root.
+	Chains [][]*x509.Certificate
+
+	// Subject is the common name of the certificate subject.
+	Subject string
+
+	// DNSNames and URIs are the subject alternative names of the certificate.
+	DNSNames []string
+	URIs     []string
+
+	// SPIFFEID is the spiffe:// URI SAN of the certificate, if it has one.
+	SPIFFEID string
+}
+
+// ClientCertOptions configures the ClientCert middleware.
+type ClientCertOptions struct {
+	// Roots is the pool of CAs that client certificates are verified against.
+	// If nil, the TLS listener is expected to have verified the certificate
+	// already (tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert)
+	// and requests without verified chains are rejected.
+	Roots *x509.CertPool
+
+	// AllowedSubjects, AllowedDNSNames, AllowedURIs and AllowedSPIFFEIDs are
+	// allow-lists matched against the leaf certificate. A certificate is
+	// accepted if it matches an entry in any of the lists. If all of the lists
+	// are empty, every verified certificate is accepted.
+	AllowedSubjects  []string
+	AllowedDNSNames  []string
+	AllowedURIs      []string
+	AllowedSPIFFEIDs []string
+
+	// Forbidden is called when the client did not present an acceptable
+	// certificate. If nil, a plain http.StatusForbidden is written.
+	Forbidden http.Handler
+}
+
+// ClientCert returns a Handler that authenticates clients via their TLS client
+// certificates. The identity of an accepted client is stored in the request
+// context and can be retrieved with GetClientIdentity.
+func ClientCert(opts ClientCertOptions) func(http.Handler) http.Handler {
+	forbidden := opts.Forbidden
+	if forbidden == nil {
+		forbidden = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Forbidden", http.StatusForbidden)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			id := verifyClientCert(req, &opts)
+			if id == nil || !opts.allowed(id) {
+				forbidden.ServeHTTP(res, req)
+				return
+			}
+			ctx := context.WithValue(req.Context(), clientIdentityKey, id)
+			next.ServeHTTP(res, req.WithContext(ctx))
+		})
+	}
+}
+
+// GetClientIdentity returns the identity stored by ClientCert, if any.
+func GetClientIdentity(req *http.Request) (*ClientIdentity, bool) {
+	id, ok := req.Context().Value(clientIdentityKey).(*ClientIdentity)
+	return id, ok
+}
+
+func verifyClientCert(req *http.Request, opts *ClientCertOptions) *ClientIdentity {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := req.TLS.PeerCertificates[0]
+
+	chains := req.TLS.VerifiedChains
+	if opts.Roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range req.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		var err error
+		chains, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         opts.Roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return nil
+		}
+	}
+	if len(chains) == 0 {
+		return nil
+	}
+
+	id := &ClientIdentity{
+		Certificate: leaf,
+		Chains:      chains,
+		Subject:     leaf.Subject.CommonName,
+		DNSNames:    leaf.DNSNames,
+	}
+	for _, uri := range leaf.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+	return id
+}
+
+func (opts *ClientCertOptions) allowed(id *ClientIdentity) bool {
+	if len(opts.AllowedSubjects) == 0 && len(opts.AllowedDNSNames) == 0 &&
+		len(opts.AllowedURIs) == 0 && len(opts.AllowedSPIFFEIDs) == 0 {
+		return true
+	}
+
+	if id.Subject != "" && contains(opts.AllowedSubjects, id.Subject) {
+		return true
+	}
+	if id.SPIFFEID != "" && contains(opts.AllowedSPIFFEIDs, id.SPIFFEID) {
+		return true
+	}
+	for _, name := range id.DNSNames {
+		if contains(opts.AllowedDNSNames, name) {
+			return true
+		}
+	}
+	for _, uri := range id.URIs {
+		if contains(opts.AllowedURIs, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func Test_ClientCert(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	client, _ := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "billing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{spiffe},
+	}, ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	for _, tt := range []struct {
+		name  string
+		opts  ClientCertOptions
+		state *tls.ConnectionState
+		code  int
+	}{
+		{"no tls", ClientCertOptions{Roots: roots}, nil, 403},
+		{"no cert", ClientCertOptions{Roots: roots}, &tls.ConnectionState{}, 403},
+		{"unverified", ClientCertOptions{}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}, 403},
+		{"untrusted", ClientCertOptions{Roots: x509.NewCertPool()}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}, 403},
+		{"trusted", ClientCertOptions{Roots: roots}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}, 200},
+		{"listener verified", ClientCertOptions{}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}, VerifiedChains: [][]*x509.Certificate{{client, ca}}}, 200},
+		{"spiffe allowed", ClientCertOptions{Roots: roots, AllowedSPIFFEIDs: []string{"spiffe://example.org/billing"}}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}, 200},
+		{"subject denied", ClientCertOptions{Roots: roots, AllowedSubjects: []string{"payments"}}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}, 403},
+	} {
+		i := interpose.New()
+		i.Use(ClientCert(tt.opts))
+		i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id, ok := GetClientIdentity(req)
+			if !ok || id.Subject != "billing" || id.SPIFFEID != "spiffe://example.org/billing" {
+				t.Errorf("%s: unexpected identity %+v", tt.name, id)
+			}
+		}))
+
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.TLS = tt.state
+		i.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: got %d wanted %d", tt.name, recorder.Code, tt.code)
+		}
+	}
+}
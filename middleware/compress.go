@@ -0,0 +1,399 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressWriter is a compressing writer that can be reused. *gzip.Writer
+// and *flate.Writer satisfy it.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Encoder returns a CompressWriter that writes to w at the given level.
+type Encoder func(w io.Writer, level int) CompressWriter
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		"gzip": func(w io.Writer, level int) CompressWriter {
+			gw, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				gw = gzip.NewWriter(w)
+			}
+			return gw
+		},
+		"deflate": func(w io.Writer, level int) CompressWriter {
+			fw, err := flate.NewWriter(w, level)
+			if err != nil {
+				fw, _ = flate.NewWriter(w, flate.DefaultCompression)
+			}
+			return fw
+		},
+	}
+)
+
+// RegisterEncoder makes a content coding, such as "br", available to
+// Compress. It replaces any encoder registered for the same coding.
+func RegisterEncoder(coding string, enc Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[strings.ToLower(coding)] = enc
+}
+
+// DefaultSkipContentTypes are media types that are already compressed. A
+// trailing "/" matches every subtype.
+var DefaultSkipContentTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-xz", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"application/pdf", "application/octet-stream",
+}
+
+// CompressOptions configures the Compress middleware.
+type CompressOptions struct {
+	// Level is the compression level passed to the encoders. Defaults to
+	// flate.DefaultCompression.
+	Level int
+
+	// NoCompression passes level 0, flate.NoCompression, to the encoders
+	// instead of Level, whose zero value stands for the default.
+	NoCompression bool
+
+	// MinSize is the smallest body, in bytes, that is compressed. Defaults
+	// to 1024. Flushed responses are compressed regardless of size.
+	MinSize int
+
+	// Encodings lists the content codings to offer, most preferred first.
+	// Each must be registered. Defaults to gzip and deflate.
+	Encodings []string
+
+	// SkipContentTypes lists media types that are sent uncompressed.
+	// Defaults to DefaultSkipContentTypes. "image/svg+xml" is always
+	// compressed.
+	SkipContentTypes []string
+}
+
+// Compress returns a Handler that compresses responses with the coding
+// preferred by the client's Accept-Encoding header. Responses that are
+// small, already encoded, or of an already-compressed media type are sent
+// as is. HEAD responses get the headers the GET response would have, as far
+// as the handler's Content-Length or body tells. Compress panics if an
+// encoding is not registered.
+func Compress(opts CompressOptions) func(http.Handler) http.Handler {
+	switch {
+	case opts.NoCompression:
+		opts.Level = flate.NoCompression
+	case opts.Level == 0:
+		opts.Level = flate.DefaultCompression
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.Encodings == nil {
+		opts.Encodings = []string{"gzip", "deflate"}
+	}
+	if opts.SkipContentTypes == nil {
+		opts.SkipContentTypes = DefaultSkipContentTypes
+	}
+
+	pools := make(map[string]*sync.Pool, len(opts.Encodings))
+	encodings := make([]string, len(opts.Encodings))
+	encodersMu.RLock()
+	for i, coding := range opts.Encodings {
+		coding = strings.ToLower(coding)
+		enc, ok := encoders[coding]
+		if !ok {
+			encodersMu.RUnlock()
+			panic("middleware: no encoder registered for " + coding)
+		}
+		level := opts.Level
+		pools[coding] = &sync.Pool{New: func() any { return enc(io.Discard, level) }}
+		encodings[i] = coding
+	}
+	encodersMu.RUnlock()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			addVary(res.Header(), "Accept-Encoding")
+			coding := negotiateEncoding(req.Header.Get("Accept-Encoding"), encodings)
+			if coding == "" {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			cw := &compressWriter{
+				wrappedWriter: wrappedWriter{res},
+				opts:          &opts,
+				coding:        coding,
+				pool:          pools[coding],
+				head:          req.Method == "HEAD",
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, req)
+		})
+	}
+}
+
+// negotiateEncoding returns the coding in offered with the highest q-value in
+// the Accept-Encoding header, preferring earlier offers on ties, or "" if
+// none is acceptable.
+func negotiateEncoding(header string, offered []string) string {
+	if header == "" {
+		return ""
+	}
+	q := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q[coding] = parseQValue(params)
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		v, ok := q[coding]
+		if !ok {
+			if v, ok = q["*"]; !ok {
+				continue
+			}
+		}
+		if v > bestQ {
+			best, bestQ = coding, v
+		}
+	}
+	return best
+}
+
+// parseQValue returns the q parameter in params, or 1 if there is none.
+func parseQValue(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 {
+			return 0
+		}
+		if q > 1 {
+			return 1
+		}
+		return q
+	}
+	return 1
+}
+
+// addVary adds value to the Vary header unless it is already listed.
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
+// compressWriter holds back the start of the body until it knows whether the
+// response is worth compressing, then either compresses or passes through
+// everything written.
+type compressWriter struct {
+	wrappedWriter
+	opts   *CompressOptions
+	coding string
+	pool   *sync.Pool
+	head   bool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     CompressWriter
+	discard bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.opts.MinSize && !w.knownSmall() {
+			return len(b), nil
+		}
+		buffered := w.buf
+		w.buf = nil
+		if err := w.decide(len(buffered) >= w.opts.MinSize, buffered); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// knownSmall reports whether the handler declared a Content-Length below
+// MinSize, so there is no need to wait for more of the body.
+func (w *compressWriter) knownSmall() bool {
+	n := w.declaredLength()
+	return n >= 0 && n < w.opts.MinSize
+}
+
+// declaredLength returns the Content-Length set by the handler, or -1.
+func (w *compressWriter) declaredLength() int {
+	n, err := strconv.Atoi(w.Header().Get("Content-Length"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// decide commits to compressing or not, sends the header and writes the
+// buffered start of the body.
+func (w *compressWriter) decide(bigEnough bool, buffered []byte) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(buffered) > 0 {
+		h.Set("Content-Type", http.DetectContentType(buffered))
+	}
+
+	if bigEnough && w.compressible() {
+		if w.head {
+			// The body of a HEAD response is not sent, so there is
+			// nothing to compress.
+			w.discard = true
+		} else {
+			w.enc = w.pool.Get().(CompressWriter)
+			w.enc.Reset(w.ResponseWriter)
+		}
+		h.Set("Content-Encoding", w.coding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		weakenETag(h)
+	} else if w.status == http.StatusNotModified && w.compressibleType() {
+		// The 304 validates the compressed representation the client has.
+		weakenETag(h)
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(buffered) == 0 || w.discard {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buffered)
+	} else {
+		_, err = w.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+// weakenETag marks a strong ETag as weak, since the compressed body is not
+// byte-for-byte the representation it was computed for.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// compressible reports whether the response may be compressed.
+func (w *compressWriter) compressible() bool {
+	switch w.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	return w.compressibleType()
+}
+
+// compressibleType reports whether the response is not already encoded and
+// its media type is not skipped.
+func (w *compressWriter) compressibleType() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, skip := range w.opts.SkipContentTypes {
+		if mediaType == skip || (strings.HasSuffix(skip, "/") && strings.HasPrefix(mediaType, skip)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		buffered := w.buf
+		w.buf = nil
+		w.decide(true, buffered)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.wrappedWriter.Flush()
+}
+
+// close writes a body that never reached MinSize uncompressed, sends the
+// header of a HEAD response whose handler wrote no body, and returns the
+// encoder to its pool.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			if !w.head {
+				// Nothing was written.
+				return
+			}
+			w.status = http.StatusOK
+		}
+		buffered := w.buf
+		w.buf = nil
+		w.decide(w.head && w.declaredLength() >= w.opts.MinSize, buffered)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(io.Discard)
+		w.pool.Put(w.enc)
+		w.enc = nil
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_NegotiateEncoding(t *testing.T) {
+	offered := []string{"gzip", "deflate"}
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"*;q=0", ""},
+		{"br", ""},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, offered); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, expected %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func Test_Compress(t *testing.T) {
+	body := strings.Repeat("interpose ", 500)
+
+	i := interpose.New()
+	i.Use(Compress(CompressOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if req.URL.Path == "/small" {
+			io.WriteString(w, "hello")
+			return
+		}
+		w.Header().Set("Content-Length", "5000")
+		io.WriteString(w, body)
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzip response but got Content-Encoding %q", recorder.Header().Get("Content-Encoding"))
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Error("Expected Content-Length to be removed")
+	}
+	if recorder.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding but got %q", recorder.Header().Get("Vary"))
+	}
+	zr, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Error("Expected the decompressed body to match")
+	}
+
+	req, _ = http.NewRequest("GET", "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Body.String() != "hello" {
+		t.Errorf("Expected a small body to be sent uncompressed but got %q", recorder.Body.String())
+	}
+}
+
+func Test_CompressFlush(t *testing.T) {
+	i := interpose.New()
+	i.Use(Compress(CompressOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	i.ServeHTTP(recorder, req)
+
+	if !recorder.Flushed {
+		t.Error("Expected the response to be flushed")
+	}
+	zr, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != "data: 1\n\n" {
+		t.Errorf("Expected the flushed event but got %q", got)
+	}
+}
+
+func Test_CompressHead(t *testing.T) {
+	body := strings.Repeat("interpose ", 500)
+
+	i := interpose.New()
+	i.Use(Compress(CompressOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5000")
+		if req.Method == "HEAD" && req.URL.Path == "/headers" {
+			return
+		}
+		io.WriteString(w, body)
+	}))
+
+	for _, path := range []string{"/", "/headers"} {
+		req, _ := http.NewRequest("HEAD", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("Expected HEAD %s to be marked gzip but got %q", path, recorder.Header().Get("Content-Encoding"))
+		}
+		if recorder.Header().Get("Content-Length") != "" {
+			t.Errorf("Expected HEAD %s to have no Content-Length", path)
+		}
+		if recorder.Body.Len() != 0 {
+			t.Errorf("Expected HEAD %s to write no body but got %d bytes", path, recorder.Body.Len())
+		}
+	}
+}
+
+func Test_CompressNoCompression(t *testing.T) {
+	body := strings.Repeat("interpose ", 500)
+
+	i := interpose.New()
+	i.Use(Compress(CompressOptions{NoCompression: true}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, body)
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Body.Len() <= len(body) {
+		t.Errorf("Expected stored blocks larger than the body but got %d bytes", recorder.Body.Len())
+	}
+	zr, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Error("Expected the decompressed body to match")
+	}
+}
+
+func Test_CompressNotModified(t *testing.T) {
+	i := interpose.New()
+	i.Use(Compress(CompressOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	for _, tt := range []struct {
+		contentType string
+		etag        string
+	}{
+		{"text/html", `W/"v1"`},
+		{"image/png", `"v1"`},
+	} {
+		req, _ := http.NewRequest("GET", "/?type="+tt.contentType, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNotModified || recorder.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s: Expected 304 with ETag %s but got %d %s", tt.contentType, tt.etag, recorder.Code, recorder.Header().Get("ETag"))
+		}
+	}
+}
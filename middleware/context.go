@@ -0,0 +1,22 @@
+package middleware
+
+// contextKey is the type of the keys under which the middleware in this
+// package store values in the request context. Being unexported, it cannot
+// collide with keys defined by other packages.
+type contextKey int
+
+const (
+	clientIdentityKey contextKey = iota
+	userKey
+	principalKey
+	sessionKey
+	csrfTokenKey
+	csrfReasonKey
+	cspNonceKey
+	clientInfoKey
+	requestIDKey
+	spanKey
+	logAttrsKey
+	loggerKey
+	routeKey
+)
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins that may make cross-origin requests.
+	// An entry may be an exact origin such as "https://example.com", a
+	// wildcard subdomain such as "https://*.example.com", or "*" to allow
+	// any origin.
+	AllowedOrigins []string
+
+	// AllowOriginFunc, if set, is consulted for origins that are not matched
+	// by AllowedOrigins.
+	AllowOriginFunc func(origin string, req *http.Request) bool
+
+	// AllowedMethods lists the methods allowed in preflighted requests.
+	// Defaults to GET, HEAD and POST.
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed in preflighted
+	// requests. "*" allows any header.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers that scripts may read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests to include cookies and HTTP
+	// authentication. It cannot be combined with the "*" origin; list the
+	// trusted origins or use AllowOriginFunc instead.
+	AllowCredentials bool
+
+	// MaxAge is how long the result of a preflight request may be cached.
+	MaxAge time.Duration
+}
+
+// CORS returns a Handler that implements Cross-Origin Resource Sharing.
+// Preflight requests are answered directly and never reach the handlers that
+// follow. CORS panics if AllowCredentials is combined with the "*" origin,
+// which would give every site credentialed access.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	if opts.AllowCredentials && contains(opts.AllowedOrigins, "*") {
+		panic(`middleware: CORS AllowCredentials cannot be used with the "*" origin`)
+	}
+	allowedMethods := []string{"GET", "HEAD", "POST"}
+	if len(opts.AllowedMethods) > 0 {
+		allowedMethods = nil
+		for _, m := range opts.AllowedMethods {
+			allowedMethods = append(allowedMethods, strings.ToUpper(m))
+		}
+	}
+	allowAnyHeader := contains(opts.AllowedHeaders, "*")
+	allowedHeaders := make(map[string]bool, len(opts.AllowedHeaders))
+	for _, h := range opts.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	methods := strings.Join(allowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := ""
+	if opts.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(opts.MaxAge / time.Second))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			h := res.Header()
+			origin := req.Header.Get("Origin")
+			preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+
+			// The response depends on Origin whether or not it is allowed,
+			// so caches must key on it.
+			h.Add("Vary", "Origin")
+
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				if origin == "" || !opts.allowedOrigin(origin, req) {
+					res.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+				if !contains(allowedMethods, method) {
+					res.WriteHeader(http.StatusNoContent)
+					return
+				}
+				requested := parseHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+				if !allowAnyHeader {
+					for _, name := range requested {
+						if !allowedHeaders[http.CanonicalHeaderKey(name)] {
+							res.WriteHeader(http.StatusNoContent)
+							return
+						}
+					}
+				}
+
+				opts.setOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", methods)
+				if len(requested) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+				}
+				if maxAge != "" {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				res.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if origin != "" && opts.allowedOrigin(origin, req) {
+				opts.setOrigin(h, origin)
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+func (opts *CORSOptions) setOrigin(h http.Header, origin string) {
+	if contains(opts.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if opts.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (opts *CORSOptions) allowedOrigin(origin string, req *http.Request) bool {
+	lower := strings.ToLower(origin)
+	for _, allowed := range opts.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == lower {
+			return true
+		}
+		if scheme, rest, ok := strings.Cut(allowed, "://*."); ok {
+			// Wildcard subdomains match any depth, but not the bare domain.
+			if strings.HasPrefix(lower, scheme+"://") && strings.HasSuffix(lower, "."+rest) &&
+				len(lower) > len(scheme+"://."+rest) {
+				return true
+			}
+		}
+	}
+	return opts.AllowOriginFunc != nil && opts.AllowOriginFunc(origin, req)
+}
+
+func parseHeaderList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
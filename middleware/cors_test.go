@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_CORS(t *testing.T) {
+	i := interpose.New()
+	i.Use(CORS(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "X-Requested-With"},
+		ExposedHeaders:   []string{"X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("router"))
+	}))
+
+	for _, tt := range []struct {
+		name    string
+		method  string
+		origin  string
+		reqMeth string
+		reqHdrs string
+		allow   string
+		body    string
+	}{
+		{"same origin", "GET", "", "", "", "", "router"},
+		{"exact", "GET", "https://app.example.com", "", "", "https://app.example.com", "router"},
+		{"wildcard", "GET", "https://a.b.example.org", "", "", "https://a.b.example.org", "router"},
+		{"bare wildcard domain", "GET", "https://example.org", "", "", "", "router"},
+		{"disallowed", "GET", "https://evil.com", "", "", "", "router"},
+		{"preflight", "OPTIONS", "https://app.example.com", "PUT", "content-type", "https://app.example.com", ""},
+		{"preflight bad method", "OPTIONS", "https://app.example.com", "DELETE", "", "", ""},
+		{"preflight bad header", "OPTIONS", "https://app.example.com", "PUT", "X-Secret", "", ""},
+	} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest(tt.method, "/items", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.reqMeth != "" {
+			r.Header.Set("Access-Control-Request-Method", tt.reqMeth)
+		}
+		if tt.reqHdrs != "" {
+			r.Header.Set("Access-Control-Request-Headers", tt.reqHdrs)
+		}
+		i.ServeHTTP(recorder, r)
+
+		h := recorder.Header()
+		if got := h.Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s: Access-Control-Allow-Origin %q wanted %q", tt.name, got, tt.allow)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("%s: body %q wanted %q", tt.name, recorder.Body.String(), tt.body)
+		}
+		if h.Get("Vary") != "Origin" {
+			t.Errorf("%s: missing Vary: Origin", tt.name)
+		}
+		if tt.name == "preflight" {
+			if recorder.Code != 204 || h.Get("Access-Control-Max-Age") != "600" ||
+				h.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+				h.Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("%s: unexpected response %d %v", tt.name, recorder.Code, h)
+			}
+		}
+		if tt.name == "exact" && h.Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+			t.Errorf("%s: missing exposed headers", tt.name)
+		}
+	}
+}
+
+func Test_CORSWildcardCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected CORS to panic for credentials with the \"*\" origin")
+		}
+	}()
+	CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -0,0 +1,292 @@
+package middleware
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CSPReport is a Content-Security-Policy violation, normalized from either
+// the legacy report-uri format or the Reporting API format.
+type CSPReport struct {
+	DocumentURL        string
+	Referrer           string
+	BlockedURL         string
+	EffectiveDirective string
+	OriginalPolicy     string
+	Disposition        string
+	SourceFile         string
+	Sample             string
+	LineNumber         int
+	ColumnNumber       int
+	StatusCode         int
+	UserAgent          string
+}
+
+// CSPReportSink receives the reports accepted by CSPReportHandler.
+type CSPReportSink interface {
+	Report(CSPReport)
+}
+
+// LogReportSink writes reports to Logger, or to the standard logger if it is
+// nil.
+type LogReportSink struct {
+	Logger *log.Logger
+}
+
+// Report satisfies the CSPReportSink interface.
+func (s LogReportSink) Report(r CSPReport) {
+	logf := log.Printf
+	if s.Logger != nil {
+		logf = s.Logger.Printf
+	}
+	logf("csp violation: %q blocked %q on %q (%q:%d:%d)",
+		r.EffectiveDirective, r.BlockedURL, r.DocumentURL, r.SourceFile, r.LineNumber, r.ColumnNumber)
+}
+
+// ChanReportSink sends reports on a channel. Reports are dropped rather than
+// blocking the handler if the channel is not ready to receive.
+type ChanReportSink chan<- CSPReport
+
+// Report satisfies the CSPReportSink interface.
+func (s ChanReportSink) Report(r CSPReport) {
+	select {
+	case s <- r:
+	default:
+	}
+}
+
+// CSPReportOptions configures CSPReportHandler.
+type CSPReportOptions struct {
+	// Sink receives the accepted reports. Defaults to a LogReportSink using
+	// the standard logger.
+	Sink CSPReportSink
+
+	// MaxBodySize is the largest request body, in bytes, that is accepted.
+	// Defaults to 64KB.
+	MaxBodySize int64
+
+	// DedupWindow is the period during which identical reports are only
+	// forwarded once. Defaults to one minute; a negative value disables
+	// deduplication.
+	DedupWindow time.Duration
+
+	// DedupSize is the most distinct reports remembered for deduplication.
+	// The oldest are forgotten first. Defaults to 10000.
+	DedupSize int
+}
+
+// CSPReportHandler returns an http.Handler to be used as the report-uri or
+// report-to endpoint of a Content-Security-Policy. It accepts legacy
+// application/csp-report and Reporting API application/reports+json
+// payloads and forwards valid violation reports to opts.Sink.
+func CSPReportHandler(opts CSPReportOptions) http.Handler {
+	if opts.Sink == nil {
+		opts.Sink = LogReportSink{}
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = 64 << 10
+	}
+	if opts.DedupWindow == 0 {
+		opts.DedupWindow = time.Minute
+	}
+	if opts.DedupSize <= 0 {
+		opts.DedupSize = 10000
+	}
+	seen := &reportDeduper{
+		window: opts.DedupWindow,
+		size:   opts.DedupSize,
+		seen:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			res.Header().Set("Allow", "POST")
+			http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, opts.MaxBodySize+1))
+		if err != nil {
+			http.Error(res, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > opts.MaxBodySize {
+			http.Error(res, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var reports []CSPReport
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/csp-report", "application/json":
+			reports, err = parseLegacyCSPReport(body)
+		case "application/reports+json":
+			reports, err = parseReportingAPI(body)
+		default:
+			http.Error(res, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(res, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		for _, r := range reports {
+			if r.DocumentURL == "" || r.EffectiveDirective == "" {
+				continue
+			}
+			if r.UserAgent == "" {
+				r.UserAgent = req.UserAgent()
+			}
+			if seen.first(r) {
+				opts.Sink.Report(r)
+			}
+		}
+		res.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func parseLegacyCSPReport(body []byte) ([]CSPReport, error) {
+	var payload struct {
+		Report struct {
+			DocumentURI        string      `json:"document-uri"`
+			Referrer           string      `json:"referrer"`
+			BlockedURI         string      `json:"blocked-uri"`
+			ViolatedDirective  string      `json:"violated-directive"`
+			EffectiveDirective string      `json:"effective-directive"`
+			OriginalPolicy     string      `json:"original-policy"`
+			Disposition        string      `json:"disposition"`
+			SourceFile         string      `json:"source-file"`
+			ScriptSample       string      `json:"script-sample"`
+			LineNumber         json.Number `json:"line-number"`
+			ColumnNumber       json.Number `json:"column-number"`
+			StatusCode         json.Number `json:"status-code"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	r := payload.Report
+	directive := r.EffectiveDirective
+	if directive == "" {
+		directive = r.ViolatedDirective
+	}
+	return []CSPReport{{
+		DocumentURL:        r.DocumentURI,
+		Referrer:           r.Referrer,
+		BlockedURL:         r.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     r.OriginalPolicy,
+		Disposition:        r.Disposition,
+		SourceFile:         r.SourceFile,
+		Sample:             r.ScriptSample,
+		LineNumber:         reportNumber(r.LineNumber),
+		ColumnNumber:       reportNumber(r.ColumnNumber),
+		StatusCode:         reportNumber(r.StatusCode),
+	}}, nil
+}
+
+func parseReportingAPI(body []byte) ([]CSPReport, error) {
+	var payload []struct {
+		Type      string `json:"type"`
+		UserAgent string `json:"user_agent"`
+		Body      struct {
+			DocumentURL        string      `json:"documentURL"`
+			Referrer           string      `json:"referrer"`
+			BlockedURL         string      `json:"blockedURL"`
+			EffectiveDirective string      `json:"effectiveDirective"`
+			OriginalPolicy     string      `json:"originalPolicy"`
+			Disposition        string      `json:"disposition"`
+			SourceFile         string      `json:"sourceFile"`
+			Sample             string      `json:"sample"`
+			LineNumber         json.Number `json:"lineNumber"`
+			ColumnNumber       json.Number `json:"columnNumber"`
+			StatusCode         json.Number `json:"statusCode"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	var reports []CSPReport
+	for _, p := range payload {
+		if p.Type != "csp-violation" {
+			continue
+		}
+		b := p.Body
+		reports = append(reports, CSPReport{
+			DocumentURL:        b.DocumentURL,
+			Referrer:           b.Referrer,
+			BlockedURL:         b.BlockedURL,
+			EffectiveDirective: b.EffectiveDirective,
+			OriginalPolicy:     b.OriginalPolicy,
+			Disposition:        b.Disposition,
+			SourceFile:         b.SourceFile,
+			Sample:             b.Sample,
+			LineNumber:         reportNumber(b.LineNumber),
+			ColumnNumber:       reportNumber(b.ColumnNumber),
+			StatusCode:         reportNumber(b.StatusCode),
+			UserAgent:          p.UserAgent,
+		})
+	}
+	return reports, nil
+}
+
+// reportNumber returns the integer in a report field, or 0 if it has none.
+func reportNumber(n json.Number) int {
+	i, _ := strconv.Atoi(string(n))
+	return i
+}
+
+// reportDeduper remembers recently forwarded reports. Reports are kept in
+// the order they were first seen, which is also the order in which they
+// expire, so expired and excess reports are dropped from the front.
+type reportDeduper struct {
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List
+}
+
+type seenReport struct {
+	key  string
+	time time.Time
+}
+
+func (d *reportDeduper) first(r CSPReport) bool {
+	if d.window < 0 {
+		return true
+	}
+	key := r.DocumentURL + "\x00" + r.EffectiveDirective + "\x00" + r.BlockedURL + "\x00" +
+		r.SourceFile + "\x00" + strconv.Itoa(r.LineNumber) + ":" + strconv.Itoa(r.ColumnNumber)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for el := d.order.Front(); el != nil && now.Sub(el.Value.(seenReport).time) > d.window; el = d.order.Front() {
+		d.forget(el)
+	}
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	if d.order.Len() >= d.size {
+		d.forget(d.order.Front())
+	}
+	d.seen[key] = d.order.PushBack(seenReport{key: key, time: now})
+	return true
+}
+
+func (d *reportDeduper) forget(el *list.Element) {
+	delete(d.seen, el.Value.(seenReport).key)
+	d.order.Remove(el)
+}
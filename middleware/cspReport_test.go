@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const legacyCSPReport = `{"csp-report": {
+	"document-uri": "https://example.com/page",
+	"blocked-uri": "https://evil.example.com/x.js",
+	"violated-directive": "script-src",
+	"line-number": 10
+}}`
+
+const reportingAPIReport = `[{
+	"type": "csp-violation",
+	"user_agent": "Browser/1.0",
+	"body": {
+		"documentURL": "https://example.com/other",
+		"blockedURL": "inline",
+		"effectiveDirective": "style-src",
+		"lineNumber": 3
+	}
+}, {
+	"type": "deprecation",
+	"body": {}
+}]`
+
+func postCSPReport(h http.Handler, contentType, body string) int {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/csp", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	h.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func Test_CSPReportHandler(t *testing.T) {
+	reports := make(chan CSPReport, 10)
+	h := CSPReportHandler(CSPReportOptions{Sink: ChanReportSink(reports), MaxBodySize: 1024})
+
+	if code := postCSPReport(h, "application/csp-report", legacyCSPReport); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for a legacy report but got %d", code)
+	}
+	r := <-reports
+	if r.DocumentURL != "https://example.com/page" || r.EffectiveDirective != "script-src" || r.LineNumber != 10 {
+		t.Errorf("Unexpected legacy report %+v", r)
+	}
+
+	if code := postCSPReport(h, "application/reports+json", reportingAPIReport); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for a Reporting API report but got %d", code)
+	}
+	r = <-reports
+	if r.DocumentURL != "https://example.com/other" || r.EffectiveDirective != "style-src" || r.UserAgent != "Browser/1.0" {
+		t.Errorf("Unexpected Reporting API report %+v", r)
+	}
+	if len(reports) != 0 {
+		t.Error("Expected reports of other types to be ignored")
+	}
+
+	if code := postCSPReport(h, "text/plain", legacyCSPReport); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for an unknown media type but got %d", code)
+	}
+	if code := postCSPReport(h, "application/csp-report", strings.Repeat(" ", 2000)+legacyCSPReport); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large body but got %d", code)
+	}
+}
+
+func Test_CSPReportDedup(t *testing.T) {
+	reports := make(chan CSPReport, 10)
+	h := CSPReportHandler(CSPReportOptions{Sink: ChanReportSink(reports), DedupSize: 2})
+
+	postCSPReport(h, "application/csp-report", legacyCSPReport)
+	postCSPReport(h, "application/csp-report", legacyCSPReport)
+	if len(reports) != 1 {
+		t.Fatalf("Expected a repeated report to be forwarded once but got %d", len(reports))
+	}
+
+	// Filling the deduper forgets the oldest report.
+	for _, line := range []string{"1", "2"} {
+		postCSPReport(h, "application/csp-report", strings.Replace(legacyCSPReport, "10", line, 1))
+	}
+	postCSPReport(h, "application/csp-report", legacyCSPReport)
+	if len(reports) != 4 {
+		t.Errorf("Expected the oldest report to be evicted but got %d reports", len(reports))
+	}
+}
+
+func Test_LogReportSink(t *testing.T) {
+	var buf bytes.Buffer
+	LogReportSink{Logger: log.New(&buf, "", 0)}.Report(CSPReport{
+		DocumentURL:        "https://example.com/\ncsp violation: forged",
+		EffectiveDirective: "script-src",
+	})
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Expected a single log line but got %q", buf.String())
+	}
+}
@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Reasons for which CSRF rejects a request. The reason for a rejection is
+// available to the failure handler through CSRFFailureReason.
+var (
+	ErrCSRFBadOrigin  = errors.New("middleware: csrf origin not allowed")
+	ErrCSRFNoReferer  = errors.New("middleware: csrf referer missing")
+	ErrCSRFBadReferer = errors.New("middleware: csrf referer not allowed")
+	ErrCSRFBadToken   = errors.New("middleware: csrf token missing or invalid")
+)
+
+const csrfTokenLength = 32
+
+// CSRFOptions configures the CSRF middleware.
+type CSRFOptions struct {
+	// CookieName is the name of the cookie holding the secret token.
+	// Defaults to "csrf_token".
+	CookieName string
+
+	// Path, Domain, Secure and SameSite set the attributes of the same name
+	// on the cookie. Path defaults to "/". The cookie is always HttpOnly.
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+
+	// MaxAge is the lifetime of the cookie. Defaults to one year.
+	MaxAge time.Duration
+
+	// HeaderName and FieldName are where the token is looked for on unsafe
+	// requests, in that order. They default to "X-CSRF-Token" and
+	// "csrf_token".
+	HeaderName string
+	FieldName  string
+
+	// ExemptPaths are request paths that are never checked. ExemptGlobs are
+	// matched the same way as the patterns of Authorize rules, and Exempt, if
+	// set, can exempt arbitrary requests.
+	ExemptPaths []string
+	ExemptGlobs []string
+	Exempt      func(*http.Request) bool
+
+	// TrustedOrigins lists origins, such as "https://example.com", that may
+	// submit requests in addition to the request's own scheme and host.
+	TrustedOrigins []string
+
+	// Failure is called when a request is rejected. If nil, a plain
+	// http.StatusForbidden is written.
+	Failure http.Handler
+}
+
+// CSRF returns a Handler that protects against cross-site request forgery.
+// A random secret is kept in a cookie, and requests with unsafe methods must
+// echo a token derived from it in a header or form field, and must come from
+// an allowed Origin (or, over TLS, Referer). The token to embed in forms is
+// available through CSRFToken.
+func CSRF(opts CSRFOptions) func(http.Handler) http.Handler {
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = 365 * 24 * time.Hour
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.FieldName == "" {
+		opts.FieldName = "csrf_token"
+	}
+	if opts.Failure == nil {
+		opts.Failure = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Forbidden", http.StatusForbidden)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Add("Vary", "Cookie")
+
+			secret := opts.secret(req)
+			if secret == nil {
+				secret = make([]byte, csrfTokenLength)
+				if _, err := rand.Read(secret); err != nil {
+					panic(err)
+				}
+				http.SetCookie(res, &http.Cookie{
+					Name:     opts.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(secret),
+					Path:     opts.Path,
+					Domain:   opts.Domain,
+					MaxAge:   int(opts.MaxAge / time.Second),
+					Secure:   opts.Secure,
+					HttpOnly: true,
+					SameSite: opts.SameSite,
+				})
+			}
+
+			ctx := context.WithValue(req.Context(), csrfTokenKey, maskToken(secret))
+			req = req.WithContext(ctx)
+
+			if !isSafeMethod(req.Method) && !opts.exempt(req) {
+				if err := opts.verify(req, secret); err != nil {
+					ctx = context.WithValue(req.Context(), csrfReasonKey, err)
+					opts.Failure.ServeHTTP(res, req.WithContext(ctx))
+					return
+				}
+			}
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// CSRFToken returns the token that must be sent back with unsafe requests,
+// for embedding in forms or templates. A fresh masked token is returned for
+// every request so that it cannot be recovered through compression side
+// channels.
+func CSRFToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfTokenKey).(string)
+	return token
+}
+
+// CSRFFailureReason returns the reason a request was rejected by CSRF, for
+// use in a failure handler.
+func CSRFFailureReason(req *http.Request) error {
+	err, _ := req.Context().Value(csrfReasonKey).(error)
+	return err
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func (opts *CSRFOptions) secret(req *http.Request) []byte {
+	cookie, err := req.Cookie(opts.CookieName)
+	if err != nil {
+		return nil
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(secret) != csrfTokenLength {
+		return nil
+	}
+	return secret
+}
+
+func (opts *CSRFOptions) exempt(req *http.Request) bool {
+	if contains(opts.ExemptPaths, cleanPath(req.URL.Path)) {
+		return true
+	}
+	for _, glob := range opts.ExemptGlobs {
+		if matchPath(glob, req.URL.Path) {
+			return true
+		}
+	}
+	return opts.Exempt != nil && opts.Exempt(req)
+}
+
+func (opts *CSRFOptions) verify(req *http.Request, secret []byte) error {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if !opts.allowedOrigin(req, origin) {
+			return ErrCSRFBadOrigin
+		}
+	} else if req.TLS != nil {
+		// Without an Origin header, fall back to the Referer over TLS, where
+		// it is reliably sent and cannot be forged by a network attacker.
+		referer := req.Referer()
+		if referer == "" {
+			return ErrCSRFNoReferer
+		}
+		if !opts.allowedOrigin(req, referer) {
+			return ErrCSRFBadReferer
+		}
+	}
+
+	token := req.Header.Get(opts.HeaderName)
+	if token == "" {
+		token = req.PostFormValue(opts.FieldName)
+	}
+	if !verifyToken(token, secret) {
+		return ErrCSRFBadToken
+	}
+	return nil
+}
+
+func (opts *CSRFOptions) allowedOrigin(req *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == req.Host && u.Scheme == requestScheme(req) {
+		return true
+	}
+	return contains(opts.TrustedOrigins, u.Scheme+"://"+u.Host)
+}
+
+// requestScheme returns the scheme the client used: the one resolved by
+// RealIP if it is in use, or else https for TLS connections and http
+// otherwise.
+func requestScheme(req *http.Request) string {
+	if info, ok := GetClientInfo(req); ok && info.Scheme != "" {
+		return info.Scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// maskToken returns secret XORed with a one-time pad, prefixed by the pad.
+func maskToken(secret []byte) string {
+	token := make([]byte, 2*len(secret))
+	if _, err := rand.Read(token[:len(secret)]); err != nil {
+		panic(err)
+	}
+	for i, b := range secret {
+		token[len(secret)+i] = token[i] ^ b
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func verifyToken(token string, secret []byte) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*len(secret) {
+		return false
+	}
+	unmasked := make([]byte, len(secret))
+	for i := range unmasked {
+		unmasked[i] = b[i] ^ b[len(secret)+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_CSRF(t *testing.T) {
+	i := interpose.New()
+	i.Use(CSRF(CSRFOptions{
+		ExemptGlobs:    []string{"/hooks/**"},
+		TrustedOrigins: []string{"https://app.example.com"},
+		Failure: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, CSRFFailureReason(req).Error(), http.StatusForbidden)
+		}),
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(CSRFToken(req)))
+	}))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/form", nil)
+	i.ServeHTTP(recorder, r)
+	if recorder.Code != 200 {
+		t.Fatalf("GET: got %d", recorder.Code)
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("GET: unexpected cookies %v", cookies)
+	}
+	token := recorder.Body.String()
+	badToken := "x" + token[1:]
+	if token[0] == 'x' {
+		badToken = "y" + token[1:]
+	}
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header string
+		form   string
+		origin string
+		tls    bool
+		code   int
+	}{
+		{"header token", "/form", token, "", "", false, 200},
+		{"form token", "/form", "", token, "http://example.com", false, 200},
+		{"trusted origin", "/form", token, "", "https://app.example.com", false, 200},
+		{"no token", "/form", "", "", "", false, 403},
+		{"bad token", "/form", badToken, "", "", false, 403},
+		{"bad origin", "/form", token, "", "https://evil.example.com", false, 403},
+		{"same origin over TLS", "/form", token, "", "https://example.com", true, 200},
+		{"insecure origin over TLS", "/form", token, "", "http://example.com", true, 403},
+		{"secure origin over plain HTTP", "/form", token, "", "https://example.com", false, 403},
+		{"exempt", "/hooks/github", "", "", "", false, 200},
+		{"traversal out of exempt", "/hooks/../form", "", "", "", false, 403},
+		{"double slash exempt", "//hooks/github", "", "", "", false, 200},
+	} {
+		form := url.Values{"csrf_token": {tt.form}}.Encode()
+		r, _ := http.NewRequest("POST", "http://example.com"+tt.path, strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-CSRF-Token", tt.header)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		r.AddCookie(cookies[0])
+
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, r)
+		if recorder.Code != tt.code {
+			t.Errorf("%s: got %d wanted %d (%s)", tt.name, recorder.Code, tt.code, recorder.Body.String())
+		}
+	}
+}
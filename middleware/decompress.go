@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DecompressOptions configures the Decompress middleware.
+type DecompressOptions struct {
+	// MaxSize is the largest decompressed body, in bytes. Reading past it
+	// fails with an *http.MaxBytesError. Defaults to 10MB.
+	MaxSize int64
+
+	// Unsupported is called when the request body has a content coding
+	// other than gzip, deflate and identity, or more than three codings.
+	// Defaults to a 415 response advertising the supported codings in
+	// Accept-Encoding.
+	Unsupported http.Handler
+
+	// Malformed is called when the compressed body cannot be decoded.
+	// Defaults to a 400 response.
+	Malformed http.Handler
+}
+
+// maxContentCodings is the most content codings Decompress removes from a
+// body, since each stacked coding multiplies its expansion.
+const maxContentCodings = 3
+
+// Decompress returns a Handler that transparently decodes request bodies
+// sent with Content-Encoding gzip or deflate. The body is decoded as it is
+// read, so downstream handlers see Content-Encoding removed and an unknown
+// Content-Length (-1).
+func Decompress(opts DecompressOptions) func(http.Handler) http.Handler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+	if opts.Unsupported == nil {
+		opts.Unsupported = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("Accept-Encoding", "gzip, deflate")
+			http.Error(res, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		})
+	}
+	if opts.Malformed == nil {
+		opts.Malformed = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			codings := parseHeaderList(strings.Join(req.Header.Values("Content-Encoding"), ","))
+			if len(codings) == 0 || req.Body == nil || req.Body == http.NoBody {
+				next.ServeHTTP(res, req)
+				return
+			}
+			if len(codings) > maxContentCodings {
+				opts.Unsupported.ServeHTTP(res, req)
+				return
+			}
+			for _, c := range codings {
+				switch strings.ToLower(c) {
+				case "gzip", "x-gzip", "deflate", "identity":
+				default:
+					opts.Unsupported.ServeHTTP(res, req)
+					return
+				}
+			}
+
+			// Codings are listed in the order they were applied, so they
+			// are removed in reverse.
+			var body io.Reader = req.Body
+			for i := len(codings) - 1; i >= 0; i-- {
+				var err error
+				switch strings.ToLower(codings[i]) {
+				case "gzip", "x-gzip":
+					body, err = gzip.NewReader(body)
+				case "deflate":
+					body, err = newDeflateReader(body)
+				}
+				if err != nil {
+					opts.Malformed.ServeHTTP(res, req)
+					return
+				}
+			}
+
+			r := req.Clone(req.Context())
+			r.Body = http.MaxBytesReader(res, readCloser{body, req.Body}, opts.MaxSize)
+			r.ContentLength = -1
+			r.Header.Del("Content-Length")
+			r.Header.Del("Content-Encoding")
+			next.ServeHTTP(res, r)
+		})
+	}
+}
+
+// newDeflateReader decodes "deflate" bodies, which should be zlib streams
+// but are sent as raw DEFLATE by some clients.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// readCloser reads from a decoder and closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
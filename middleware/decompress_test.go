@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func decompressServer(opts DecompressOptions) *interpose.Middleware {
+	i := interpose.New()
+	i.Use(Decompress(opts))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Header.Get("Content-Encoding") != "" || req.ContentLength != -1 {
+			http.Error(w, "headers not updated", http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}))
+	return i
+}
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func Test_Decompress(t *testing.T) {
+	body := []byte(strings.Repeat("interpose ", 100))
+
+	var zlibBody, rawBody bytes.Buffer
+	zw := zlib.NewWriter(&zlibBody)
+	zw.Write(body)
+	zw.Close()
+	fw, _ := flate.NewWriter(&rawBody, flate.DefaultCompression)
+	fw.Write(body)
+	fw.Close()
+
+	tests := []struct {
+		name, encoding string
+		body           []byte
+	}{
+		{"gzip", "gzip", gzipped(body)},
+		{"zlib", "deflate", zlibBody.Bytes()},
+		{"raw deflate", "deflate", rawBody.Bytes()},
+		{"stacked", "gzip, gzip", gzipped(gzipped(body))},
+		{"identity", "identity", body},
+	}
+
+	i := decompressServer(DecompressOptions{})
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Encoding", tt.encoding)
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), body) {
+			t.Errorf("%s: Expected the decoded body but got %d %q", tt.name, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func Test_DecompressRejected(t *testing.T) {
+	body := []byte(strings.Repeat("interpose ", 100))
+
+	tests := []struct {
+		name, encoding string
+		body           []byte
+		code           int
+	}{
+		{"br", "br", body, http.StatusUnsupportedMediaType},
+		{"too many codings", "gzip, gzip, gzip, gzip", gzipped(gzipped(gzipped(gzipped(body)))), http.StatusUnsupportedMediaType},
+		{"malformed", "gzip", body, http.StatusBadRequest},
+		{"too large", "gzip", gzipped(bytes.Repeat(body, 10)), http.StatusRequestEntityTooLarge},
+	}
+
+	i := decompressServer(DecompressOptions{MaxSize: 5000})
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Encoding", tt.encoding)
+		recorder := httptest.NewRecorder()
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: Expected %d but got %d", tt.name, tt.code, recorder.Code)
+		}
+		if tt.code == http.StatusUnsupportedMediaType && recorder.Header().Get("Accept-Encoding") != "gzip, deflate" {
+			t.Errorf("%s: Expected the supported codings to be advertised", tt.name)
+		}
+	}
+}
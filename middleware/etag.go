@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagOptions configures the ETag middleware.
+type ETagOptions struct {
+	// Weak marks generated tags as weak (W/"..."). Strong tags are
+	// generated by default.
+	Weak bool
+
+	// MaxSize is the largest body, in bytes, that is buffered and tagged.
+	// Larger responses are streamed untagged. Defaults to 1MB.
+	MaxSize int64
+
+	// Skip, if set, disables the middleware for the requests it returns
+	// true for.
+	Skip func(*http.Request) bool
+
+	// OnError, if set, is called when the response cannot be sent.
+	OnError func(*http.Request, error)
+}
+
+// ETag returns a Handler that buffers GET and HEAD responses, tags 200
+// responses that have no ETag with a hash of the body, and answers
+// If-None-Match and If-Modified-Since with 304 Not Modified. Responses that
+// already carry an ETag or Last-Modified are validated but not retagged.
+// HEAD responses are only validated, since their handlers need not write
+// the body the tag would be computed from. Protocol upgrades, such as
+// WebSocket handshakes, are passed through unbuffered.
+//
+// Used with Compress, ETag should come after it, so that the tag is computed
+// on the uncompressed body and Compress can weaken it for each coding.
+func ETag(opts ETagOptions) func(http.Handler) http.Handler {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 1 << 20
+	}
+	bopts := &BufferOptions{MaxSize: opts.MaxSize, OnError: opts.OnError}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if (req.Method != "GET" && req.Method != "HEAD") || isUpgrade(req) || (opts.Skip != nil && opts.Skip(req)) {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			bw := newBufferWriter(res, req, bopts)
+			defer bw.release()
+			next.ServeHTTP(bw, req)
+
+			if bw.Buffered() && bw.Status() == http.StatusOK {
+				h := bw.Header()
+				if h.Get("ETag") == "" && h.Get("Content-Encoding") == "" && req.Method != "HEAD" {
+					h.Set("ETag", bodyETag(bw.Bytes(), opts.Weak))
+				}
+				if notModified(req, h) {
+					bw.Reset()
+					bw.status = http.StatusNotModified
+					for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+						h.Del(k)
+					}
+				}
+			}
+			bw.apply()
+		})
+	}
+}
+
+// bodyETag returns a tag derived from the SHA-256 hash of body.
+func bodyETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// notModified evaluates If-None-Match and, in its absence,
+// If-Modified-Since against the response header, following RFC 9110
+// section 13.2.2.
+func notModified(req *http.Request, h http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, h.Get("ETag"), false)
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lm.Truncate(time.Second).After(ims)
+}
+
+// etagMatch reports whether the list of entity tags in header, or "*",
+// matches etag. The weak comparison ignores W/ prefixes; the strong
+// comparison requires both tags to be strong.
+func etagMatch(header, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range parseHeaderList(header) {
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
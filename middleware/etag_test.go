@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_ETag(t *testing.T) {
+	i := interpose.New()
+	i.Use(ETag(ETagOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		fmt.Fprint(w, "hello")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(recorder, req)
+
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" || etag[0] != '"' {
+		t.Fatalf("Expected a 200 with a strong ETag but got %d %q", recorder.Code, etag)
+	}
+
+	tests := []struct {
+		header, value string
+		code          int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Tue, 20 Oct 2015 07:28:00 GMT", http.StatusOK},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set(tt.header, tt.value)
+		i.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: %s: expected %d but got %d", tt.header, tt.value, tt.code, recorder.Code)
+		}
+		if tt.code == http.StatusNotModified && (recorder.Body.Len() != 0 || recorder.Header().Get("Content-Type") != "") {
+			t.Errorf("%s: %s: expected a 304 with no body or Content-Type", tt.header, tt.value)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") != "" {
+		t.Error("Expected POST requests to be skipped")
+	}
+}
+
+func Test_ETagHead(t *testing.T) {
+	i := interpose.New()
+	i.Use(ETag(ETagOptions{}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/tagged" {
+			w.Header().Set("ETag", `"v1"`)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if req.Method == "GET" {
+			fmt.Fprint(w, "hello")
+		}
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("HEAD", "/", nil)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") != "" {
+		t.Errorf("Expected HEAD to be left untagged but got %d %q", recorder.Code, recorder.Header().Get("ETag"))
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("HEAD", "/tagged", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected HEAD to be validated against the handler's ETag but got %d", recorder.Code)
+	}
+}
+
+// hijackRecorder is a ResponseRecorder whose connection can be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func Test_BufferingUpgrade(t *testing.T) {
+	i := interpose.New()
+	i.Use(Range(RangeOptions{}))
+	i.Use(ETag(ETagOptions{}))
+	i.Use(NewCache(CacheOptions{}).Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "cannot hijack", http.StatusInternalServerError)
+			return
+		}
+		hj.Hijack()
+	}))
+
+	req, _ := http.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	i.ServeHTTP(recorder, req)
+
+	if !recorder.hijacked {
+		t.Errorf("Expected the upgrade to hijack the connection but got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
@@ -1,19 +1,218 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/carbocation/handlers"
 )
 
+// Log formats for GorillaLogOptions. Any other format is parsed as a
+// text/template executed with a LogEntry.
+const (
+	CommonLogFormat   = `{{.Host}} - {{.User}} [{{.Timestamp}}] "{{.Request}}" {{.Status}} {{.Size}}`
+	CombinedLogFormat = CommonLogFormat + ` "{{.Referer}}" "{{.UserAgent}}"`
+)
+
+// LogField selects an extra field appended to each log line. The middleware
+// that provide the fields must be added before the logger.
+type LogField int
+
+const (
+	// LogRequestID appends the ID assigned by RequestID.
+	LogRequestID LogField = iota
+	// LogLatency appends the time taken to serve the request.
+	LogLatency
+	// LogRealIP appends the client IP resolved by RealIP.
+	LogRealIP
+)
+
+// LogEntry holds the values available to log templates. URI, Referer and
+// UserAgent are escaped as the Gorilla handlers do, so that they can be
+// written between double quotes.
+type LogEntry struct {
+	Host      string
+	User      string
+	Time      time.Time
+	Method    string
+	URI       string
+	Proto     string
+	Status    int
+	Size      int64
+	Referer   string
+	UserAgent string
+	RequestID string
+	Latency   time.Duration
+	RealIP    string
+}
+
+// Timestamp returns the time at which the request started, in the format of
+// the Apache logs.
+func (e *LogEntry) Timestamp() string {
+	return e.Time.Format("02/Jan/2006:15:04:05 -0700")
+}
+
+// Request returns the request line.
+func (e *LogEntry) Request() string {
+	return e.Method + " " + e.URI + " " + e.Proto
+}
+
+// GorillaLogOptions configures GorillaLogWithOptions.
+type GorillaLogOptions struct {
+	// Out is where log lines are written. Defaults to os.Stdout.
+	Out io.Writer
+
+	// Format is CommonLogFormat, CombinedLogFormat (the default) or a custom
+	// text/template.
+	Format string
+
+	// Fields are appended to each line as key=value pairs.
+	Fields []LogField
+
+	// OnError, if set, is called when a line cannot be rendered or written.
+	// Lines whose template fails are dropped.
+	OnError func(*http.Request, error)
+}
+
 /*
 Wraps the Gorilla Logger
 */
 func GorillaLog() func(http.Handler) http.Handler {
+	return GorillaLogWithOptions(GorillaLogOptions{})
+}
+
+// GorillaLogWithOptions is GorillaLog with a configurable destination, format
+// and extra fields. The plain Common and Combined formats are written by the
+// Gorilla handlers; custom templates and extra fields are rendered by this
+// package. GorillaLogWithOptions panics if the template cannot be parsed.
+func GorillaLogWithOptions(opts GorillaLogOptions) func(http.Handler) http.Handler {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	if opts.Format == "" {
+		opts.Format = CombinedLogFormat
+	}
+
+	if len(opts.Fields) == 0 && (opts.Format == CommonLogFormat || opts.Format == CombinedLogFormat) {
+		return func(next http.Handler) http.Handler {
+			if opts.Format == CommonLogFormat {
+				return handlers.LoggingHandler(opts.Out, next)
+			}
+			return handlers.CombinedLoggingHandler(opts.Out, next)
+		}
+	}
+
+	tmpl := template.Must(template.New("log").Parse(opts.Format))
+	var mu sync.Mutex
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			handlers.CombinedLoggingHandler(os.Stdout, next).ServeHTTP(w, r)
+			start := time.Now()
+			sw := &statusWriter{wrappedWriter: wrappedWriter{w}}
+			// BasicAuth usually runs inside the logger, so the user it
+			// authenticates is collected as a log attribute on the way out.
+			extra, ok := r.Context().Value(logAttrsKey).(*logAttrs)
+			if !ok {
+				extra = &logAttrs{}
+				r = r.WithContext(context.WithValue(r.Context(), logAttrsKey, extra))
+			}
+			next.ServeHTTP(sw, r)
+
+			entry := newLogEntry(r, start, sw, extra)
+			var line bytes.Buffer
+			if err := tmpl.Execute(&line, entry); err != nil {
+				if opts.OnError != nil {
+					opts.OnError(r, err)
+				}
+				return
+			}
+			for _, f := range opts.Fields {
+				switch f {
+				case LogRequestID:
+					line.WriteString(" request_id=" + orDash(GetRequestID(r)))
+				case LogLatency:
+					line.WriteString(" latency=" + entry.Latency.String())
+				case LogRealIP:
+					line.WriteString(" real_ip=" + entry.RealIP)
+				}
+			}
+			line.WriteByte('\n')
+
+			mu.Lock()
+			_, err := opts.Out.Write(line.Bytes())
+			mu.Unlock()
+			if err != nil && opts.OnError != nil {
+				opts.OnError(r, err)
+			}
 		})
 	}
 }
+
+func newLogEntry(r *http.Request, start time.Time, sw *statusWriter, extra *logAttrs) *LogEntry {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	proto := r.Proto
+	if proto == "" {
+		proto = "HTTP/" + strconv.Itoa(r.ProtoMajor) + "." + strconv.Itoa(r.ProtoMinor)
+	}
+	return &LogEntry{
+		Host:      host,
+		User:      logUser(r, extra),
+		Time:      start,
+		Method:    r.Method,
+		URI:       logQuote(uri),
+		Proto:     proto,
+		Status:    sw.Status(),
+		Size:      sw.bytes,
+		Referer:   logQuote(r.Referer()),
+		UserAgent: logQuote(r.UserAgent()),
+		RequestID: GetRequestID(r),
+		Latency:   time.Since(start),
+		RealIP:    ClientIP(r),
+	}
+}
+
+// logUser returns the user authenticated by BasicAuth, whether it ran
+// around the logger or inside it, or else the user in the request URL.
+func logUser(r *http.Request, extra *logAttrs) string {
+	if user, ok := GetUser(r); ok && user != "" {
+		return string(user)
+	}
+	for _, attr := range extra.get() {
+		if attr.Key == "user" && attr.Value.String() != "" {
+			return attr.Value.String()
+		}
+	}
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		return r.URL.User.Username()
+	}
+	return "-"
+}
+
+// logQuote escapes quotes, backslashes and unprintable characters in s, as
+// strconv.Quote does, without adding the surrounding quotes.
+func logQuote(s string) string {
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
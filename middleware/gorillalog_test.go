@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_GorillaLogTemplate(t *testing.T) {
+	var buf bytes.Buffer
+
+	i := interpose.New()
+	i.Use(RequestID(RequestIDOptions{}))
+	i.Use(GorillaLogWithOptions(GorillaLogOptions{
+		Out:    &buf,
+		Fields: []LogField{LogRequestID},
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+
+	req, _ := http.NewRequest("GET", "/foo?q=%22x%22", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Referer", `http://example.com/"quoted"`)
+	req.Header.Set("User-Agent", "agent\\1\n\"forged\" line")
+	i.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("Expected a single line but got %q", line)
+	}
+	if !strings.HasPrefix(line, `192.0.2.1 - - [`) {
+		t.Errorf("Expected the line to start with the host but got %q", line)
+	}
+	for _, want := range []string{
+		`"GET /foo?q=%22x%22 HTTP/1.1" 418 5`,
+		`"http://example.com/\"quoted\""`,
+		`"agent\\1\n\"forged\" line"`,
+		" request_id=",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected the line to contain %q but got %q", want, line)
+		}
+	}
+}
+
+func Test_GorillaLogUser(t *testing.T) {
+	for _, inside := range []bool{true, false} {
+		var buf bytes.Buffer
+		logger := GorillaLogWithOptions(GorillaLogOptions{Out: &buf, Format: "{{.User}}"})
+
+		i := interpose.New()
+		if inside {
+			i.Use(BasicAuth("john", "doe"))
+			i.Use(logger)
+		} else {
+			i.Use(logger)
+			i.Use(BasicAuth("john", "doe"))
+		}
+		i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+		req, _ := http.NewRequest("GET", "http://url-user@example.com/", nil)
+		req.SetBasicAuth("john", "doe")
+		i.ServeHTTP(httptest.NewRecorder(), req)
+
+		if buf.String() != "john\n" {
+			t.Errorf("Expected the BasicAuth user (logger inside: %v) but got %q", inside, buf.String())
+		}
+	}
+}
+
+func Test_GorillaLogTemplateError(t *testing.T) {
+	var buf bytes.Buffer
+	var reported error
+
+	i := interpose.New()
+	i.Use(GorillaLogWithOptions(GorillaLogOptions{
+		Out:     &buf,
+		Format:  "{{.Missing}}",
+		OnError: func(req *http.Request, err error) { reported = err },
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(httptest.NewRecorder(), req)
+
+	if reported == nil {
+		t.Error("Expected the template error to be reported")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no line to be written but got %q", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func Test_GorillaLogWriteError(t *testing.T) {
+	var reported error
+
+	i := interpose.New()
+	i.Use(GorillaLogWithOptions(GorillaLogOptions{
+		Out:     failingWriter{},
+		Fields:  []LogField{LogLatency},
+		OnError: func(req *http.Request, err error) { reported = err },
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(httptest.NewRecorder(), req)
+
+	if reported == nil || reported.Error() != "disk full" {
+		t.Errorf("Expected the write error to be reported but got %v", reported)
+	}
+}
@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HMACOptions configures the HMACSignature middleware.
+type HMACOptions struct {
+	// Secret is the shared key used to compute the HMAC-SHA256 signature.
+	Secret []byte
+
+	// Header is the request header that carries the signature. Defaults to
+	// "X-Signature".
+	Header string
+
+	// Prefix is stripped from the signature header before decoding, e.g.
+	// "sha256=".
+	Prefix string
+
+	// Base64 indicates that the signature is base64 encoded rather than hex
+	// encoded.
+	Base64 bool
+
+	// TimestampHeader, if set, names a header carrying the unix time at which
+	// the request was signed. Requests without it, or whose timestamp is more
+	// than Tolerance away from the current time, are rejected.
+	TimestampHeader string
+
+	// Tolerance is the allowed clock skew for timestamps. Signatures are
+	// remembered by the ReplayCache for twice Tolerance, the longest a
+	// timestamp stays acceptable. Defaults to five minutes.
+	Tolerance time.Duration
+
+	// SigningString builds the message that is signed from the timestamp
+	// (empty if TimestampHeader is unset) and the request body. Defaults to
+	// the body alone, or "timestamp.body" when a timestamp is present.
+	SigningString func(timestamp string, body []byte, req *http.Request) []byte
+
+	// ReplayCache, if set, rejects signatures that have already been seen.
+	// Signatures are only remembered for a limited time, so a ReplayCache
+	// requires TimestampHeader: without it, a captured request could be
+	// replayed once its signature is forgotten.
+	ReplayCache ReplayCache
+
+	// MaxBodySize is the largest body, in bytes, that will be read. Larger
+	// requests are rejected with http.StatusRequestEntityTooLarge. Defaults
+	// to 1MB.
+	MaxBodySize int64
+
+	// Unauthorized is called when the signature is missing or invalid. If
+	// nil, a plain http.StatusUnauthorized is written.
+	Unauthorized http.Handler
+}
+
+// ReplayCache remembers signatures that have already been accepted.
+type ReplayCache interface {
+	// Add records key until expires and reports whether it was not already
+	// present.
+	Add(key string, expires time.Time) bool
+}
+
+// HMACSignature returns a Handler that verifies the HMAC-SHA256 signature of
+// the request body, as is commonly done for incoming webhooks. The body is
+// restored after it has been read so that later handlers can still consume it.
+// HMACSignature panics if a ReplayCache is given without a TimestampHeader.
+func HMACSignature(opts HMACOptions) func(http.Handler) http.Handler {
+	if opts.ReplayCache != nil && opts.TimestampHeader == "" {
+		panic("middleware: HMACSignature ReplayCache requires TimestampHeader")
+	}
+	if opts.Header == "" {
+		opts.Header = "X-Signature"
+	}
+	if opts.Tolerance == 0 {
+		opts.Tolerance = 5 * time.Minute
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	if opts.SigningString == nil {
+		opts.SigningString = defaultSigningString
+	}
+	if opts.Unauthorized == nil {
+		opts.Unauthorized = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Invalid signature", http.StatusUnauthorized)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			sig, ok := opts.signature(req)
+			if !ok {
+				opts.Unauthorized.ServeHTTP(res, req)
+				return
+			}
+
+			var timestamp string
+			if opts.TimestampHeader != "" {
+				timestamp = req.Header.Get(opts.TimestampHeader)
+				if !opts.fresh(timestamp) {
+					opts.Unauthorized.ServeHTTP(res, req)
+					return
+				}
+			}
+
+			var body []byte
+			if req.Body != nil {
+				var err error
+				body, err = io.ReadAll(io.LimitReader(req.Body, opts.MaxBodySize+1))
+				req.Body.Close()
+				if err != nil {
+					http.Error(res, "Bad Request", http.StatusBadRequest)
+					return
+				}
+				if int64(len(body)) > opts.MaxBodySize {
+					http.Error(res, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+
+			mac := hmac.New(sha256.New, opts.Secret)
+			mac.Write(opts.SigningString(timestamp, body, req))
+			if !hmac.Equal(sig, mac.Sum(nil)) {
+				opts.Unauthorized.ServeHTTP(res, req)
+				return
+			}
+
+			if opts.ReplayCache != nil &&
+				!opts.ReplayCache.Add(string(sig), time.Now().Add(2*opts.Tolerance)) {
+				opts.Unauthorized.ServeHTTP(res, req)
+				return
+			}
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+func (opts *HMACOptions) signature(req *http.Request) ([]byte, bool) {
+	value := req.Header.Get(opts.Header)
+	if value == "" || !strings.HasPrefix(value, opts.Prefix) {
+		return nil, false
+	}
+	value = value[len(opts.Prefix):]
+
+	var sig []byte
+	var err error
+	if opts.Base64 {
+		sig, err = base64.StdEncoding.DecodeString(value)
+	} else {
+		sig, err = hex.DecodeString(value)
+	}
+	return sig, err == nil && len(sig) == sha256.Size
+}
+
+func (opts *HMACOptions) fresh(timestamp string) bool {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(secs, 0))
+	return skew <= opts.Tolerance && skew >= -opts.Tolerance
+}
+
+func defaultSigningString(timestamp string, body []byte, req *http.Request) []byte {
+	if timestamp == "" {
+		return body
+	}
+	return append([]byte(timestamp+"."), body...)
+}
+
+// MemoryReplayCache is a ReplayCache that keeps its entries in memory.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	sweeper sweeper
+}
+
+// NewMemoryReplayCache returns an empty MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: make(map[string]time.Time)}
+}
+
+// Add satisfies the ReplayCache interface. Expired entries are pruned every
+// so often as new ones are added.
+func (c *MemoryReplayCache) Add(key string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	sweep(&c.sweeper, c.entries, now.After)
+
+	if exp, ok := c.entries[key]; ok && !now.After(exp) {
+		return false
+	}
+	c.entries[key] = expires
+	return true
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func hmacSHA256Hex(secret, msg string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_HMACSignature(t *testing.T) {
+	i := interpose.New()
+	i.Use(HMACSignature(HMACOptions{
+		Secret:          []byte("s3cret"),
+		Prefix:          "sha256=",
+		TimestampHeader: "X-Timestamp",
+		ReplayCache:     NewMemoryReplayCache(),
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Write(body)
+	}))
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	for _, tt := range []struct {
+		name      string
+		timestamp string
+		signature string
+		code      int
+	}{
+		{"missing", now, "", 401},
+		{"wrong secret", now, "sha256=" + hmacSHA256Hex("other", now+".payload"), 401},
+		{"stale", stale, "sha256=" + hmacSHA256Hex("s3cret", stale+".payload"), 401},
+		{"valid", now, "sha256=" + hmacSHA256Hex("s3cret", now+".payload"), 200},
+		{"replayed", now, "sha256=" + hmacSHA256Hex("s3cret", now+".payload"), 401},
+	} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/hook", strings.NewReader("payload"))
+		r.Header.Set("X-Timestamp", tt.timestamp)
+		r.Header.Set("X-Signature", tt.signature)
+		i.ServeHTTP(recorder, r)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: got %d wanted %d", tt.name, recorder.Code, tt.code)
+		}
+		if tt.code == 200 && recorder.Body.String() != "payload" {
+			t.Errorf("%s: body not restored, got %q", tt.name, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// CIDRSet is a set of IP prefixes stored in a binary prefix tree, so that
+// lookups take time proportional to the address length rather than to the
+// number of prefixes.
+type CIDRSet struct {
+	v4, v6 cidrNode
+}
+
+type cidrNode struct {
+	child    [2]*cidrNode
+	terminal bool
+}
+
+// NewCIDRSet returns a set of the given CIDRs. Bare addresses are treated as
+// single-host prefixes.
+func NewCIDRSet(cidrs ...string) (*CIDRSet, error) {
+	s := &CIDRSet{}
+	for _, c := range cidrs {
+		if err := s.Add(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add inserts a CIDR or bare address into the set.
+func (s *CIDRSet) Add(cidr string) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		ip, ipErr := netip.ParseAddr(cidr)
+		if ipErr != nil {
+			return fmt.Errorf("middleware: invalid CIDR or address %q", cidr)
+		}
+		p = netip.PrefixFrom(ip, ip.BitLen())
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	p = p.Masked()
+
+	n := s.root(p.Addr())
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		if n.terminal {
+			// Already covered by a shorter prefix.
+			return nil
+		}
+		bit := b[i/8] >> (7 - uint(i%8)) & 1
+		if n.child[bit] == nil {
+			n.child[bit] = &cidrNode{}
+		}
+		n = n.child[bit]
+	}
+	n.terminal = true
+	n.child = [2]*cidrNode{}
+	return nil
+}
+
+// Contains reports whether ip is covered by a prefix in the set.
+func (s *CIDRSet) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() {
+		return false
+	}
+	n := s.root(ip)
+	b := ip.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.terminal {
+			return true
+		}
+		if i == len(b)*8 {
+			break
+		}
+		n = n.child[b[i/8]>>(7-uint(i%8))&1]
+	}
+	return false
+}
+
+func (s *CIDRSet) root(ip netip.Addr) *cidrNode {
+	if ip.Is4() {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+// IPFilterOptions configures an IPFilter.
+type IPFilterOptions struct {
+	// Allow lists the CIDRs that may access the protected handlers. If it is
+	// empty, every address that is not denied is allowed.
+	Allow []string
+
+	// Deny lists CIDRs that are refused even if they are also allowed.
+	Deny []string
+
+	// Forbidden is called for refused requests. If nil, a plain
+	// http.StatusForbidden is written.
+	Forbidden http.Handler
+}
+
+// IPFilter restricts access by client IP address. The client IP is the one
+// resolved by RealIP if it is in use, so that requests relayed by trusted
+// proxies are filtered on the address of the real client. The lists can be
+// replaced while serving with Update or LoadFile.
+type IPFilter struct {
+	rules     atomic.Pointer[ipFilterRules]
+	forbidden http.Handler
+}
+
+type ipFilterRules struct {
+	allow, deny *CIDRSet
+	allowAll    bool
+}
+
+// NewIPFilter returns an IPFilter for the given options.
+func NewIPFilter(opts IPFilterOptions) (*IPFilter, error) {
+	f := &IPFilter{forbidden: opts.Forbidden}
+	if f.forbidden == nil {
+		f.forbidden = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Forbidden", http.StatusForbidden)
+		})
+	}
+	if err := f.Update(opts.Allow, opts.Deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update atomically replaces the allow and deny lists.
+func (f *IPFilter) Update(allow, deny []string) error {
+	a, err := NewCIDRSet(allow...)
+	if err != nil {
+		return err
+	}
+	d, err := NewCIDRSet(deny...)
+	if err != nil {
+		return err
+	}
+	f.rules.Store(&ipFilterRules{allow: a, deny: d, allowAll: len(allow) == 0})
+	return nil
+}
+
+// LoadFile replaces the allow and deny lists with those read from the named
+// file. Each line holds a CIDR or address, optionally preceded by "allow" or
+// "deny" (the default is allow). Blank lines and lines starting with # are
+// ignored. The lists are left unchanged if the file cannot be parsed.
+func (f *IPFilter) LoadFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var allow, deny []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch {
+		case len(fields) == 1:
+			allow = append(allow, fields[0])
+		case len(fields) == 2 && fields[0] == "allow":
+			allow = append(allow, fields[1])
+		case len(fields) == 2 && fields[0] == "deny":
+			deny = append(deny, fields[1])
+		default:
+			return fmt.Errorf("middleware: %s:%d: invalid rule %q", name, line, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return f.Update(allow, deny)
+}
+
+// Allowed reports whether ip passes the filter.
+func (f *IPFilter) Allowed(ip netip.Addr) bool {
+	rules := f.rules.Load()
+	if rules.deny.Contains(ip) {
+		return false
+	}
+	return rules.allowAll || rules.allow.Contains(ip)
+}
+
+// Handler passes on to next the requests whose client IP, as resolved by
+// ClientIP, is allowed, and refuses the others with the Forbidden handler.
+func (f *IPFilter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ip, err := netip.ParseAddr(ClientIP(req))
+		if err != nil || !f.Allowed(ip) {
+			f.forbidden.ServeHTTP(res, req)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_CIDRSet(t *testing.T) {
+	s, err := NewCIDRSet("10.0.0.0/8", "192.0.2.7", "2001:db8::/32", "::ffff:198.51.100.0/120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.0.2.7":       true,
+		"192.0.2.8":       false,
+		"::ffff:10.9.9.9": true,
+		"2001:db8:1::1":   true,
+		"2001:db9::1":     false,
+		"198.51.100.200":  true,
+		"198.51.101.1":    false,
+		"::a00:1":         false,
+	} {
+		if got := s.Contains(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Contains(%s) = %v, wanted %v", ip, got, want)
+		}
+	}
+
+	if _, err := NewCIDRSet("10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+func Test_IPFilter(t *testing.T) {
+	f, err := NewIPFilter(IPFilterOptions{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.6.6.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := interpose.New()
+	i.Use(RealIP(RealIPOptions{TrustedProxies: []string{"127.0.0.1"}}))
+	i.Use(f.Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	check := func(remote, forwarded string, want int) {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/admin", nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		i.ServeHTTP(recorder, r)
+		if recorder.Code != want {
+			t.Errorf("%s (%s): got %d wanted %d", remote, forwarded, recorder.Code, want)
+		}
+	}
+
+	check("10.1.1.1:1000", "", 200)
+	check("10.6.6.6:1000", "", 403)
+	check("192.0.2.1:1000", "", 403)
+	check("127.0.0.1:1000", "10.1.1.1", 200)
+	check("127.0.0.1:1000", "192.0.2.1", 403)
+	check("192.0.2.1:1000", "10.1.1.1", 403)
+
+	name := filepath.Join(t.TempDir(), "ips")
+	os.WriteFile(name, []byte("# office\n192.0.2.0/24\ndeny 192.0.2.66\n"), 0644)
+	if err := f.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	check("192.0.2.1:1000", "", 200)
+	check("192.0.2.66:1000", "", 403)
+	check("10.1.1.1:1000", "", 403)
+
+	os.WriteFile(name, []byte("permit 10.0.0.0/8\n"), 0644)
+	if err := f.LoadFile(name); err == nil {
+		t.Error("invalid rule accepted")
+	}
+	check("192.0.2.1:1000", "", 200)
+}
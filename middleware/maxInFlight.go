@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority classifies requests for MaxInFlight.
+type Priority int
+
+const (
+	// PriorityLow requests are queued behind PriorityNormal ones and are
+	// the first to be shed when the server is overloaded.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the default priority.
+	PriorityNormal
+	// PriorityCritical requests, such as health checks, are never queued
+	// or shed and do not count towards the limit.
+	PriorityCritical
+)
+
+// MaxInFlightOptions configures the MaxInFlight middleware.
+type MaxInFlightOptions struct {
+	// Limit is the maximum number of requests served concurrently.
+	Limit int
+
+	// QueueSize is the number of requests that may wait for a slot once
+	// Limit is reached. Further requests are rejected immediately.
+	QueueSize int
+
+	// QueueTimeout is how long a request may wait in the queue. Defaults to
+	// one second.
+	QueueTimeout time.Duration
+
+	// Adaptive enables load shedding based on observed latency. When the
+	// average latency rises above TargetLatency, the concurrency limit is
+	// lowered (down to MinLimit) and PriorityLow requests are rejected
+	// without queueing. The limit recovers as latency falls again.
+	Adaptive      bool
+	TargetLatency time.Duration
+	MinLimit      int
+
+	// Priority classifies requests. Defaults to PriorityNormal for all.
+	Priority func(*http.Request) Priority
+
+	// RetryAfter is sent with rejected requests. Defaults to one second.
+	RetryAfter time.Duration
+
+	// Rejected is called for requests that are shed. If nil, a plain
+	// http.StatusServiceUnavailable is written. The Retry-After header has
+	// already been set when it is called.
+	Rejected http.Handler
+}
+
+// MaxInFlight returns a Handler that limits the number of requests served
+// concurrently, queueing a bounded number of the excess and shedding the
+// rest with http.StatusServiceUnavailable.
+func MaxInFlight(opts MaxInFlightOptions) func(http.Handler) http.Handler {
+	mw, _ := maxInFlight(opts)
+	return mw
+}
+
+// maxInFlight is MaxInFlight, also returning the limiter for inspection.
+func maxInFlight(opts MaxInFlightOptions) (func(http.Handler) http.Handler, *inFlightLimiter) {
+	if opts.Limit <= 0 {
+		panic("middleware: MaxInFlight requires a positive Limit")
+	}
+	if opts.QueueTimeout == 0 {
+		opts.QueueTimeout = time.Second
+	}
+	if opts.Adaptive && opts.TargetLatency <= 0 {
+		panic("middleware: adaptive MaxInFlight requires a TargetLatency")
+	}
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 1
+	}
+	if opts.RetryAfter == 0 {
+		opts.RetryAfter = time.Second
+	}
+	if opts.Rejected == nil {
+		opts.Rejected = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Service Unavailable", http.StatusServiceUnavailable)
+		})
+	}
+	l := &inFlightLimiter{opts: &opts, limit: float64(opts.Limit)}
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			prio := PriorityNormal
+			if opts.Priority != nil {
+				prio = opts.Priority(req)
+			}
+			if prio >= PriorityCritical {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			if !l.acquire(req, prio) {
+				res.Header().Set("Retry-After", headerSeconds(opts.RetryAfter))
+				opts.Rejected.ServeHTTP(res, req)
+				return
+			}
+			start := time.Now()
+			defer func() { l.release(time.Since(start)) }()
+			next.ServeHTTP(res, req)
+		})
+	}
+	return mw, l
+}
+
+// inFlightLimiter is a semaphore with a bounded, prioritized FIFO queue and
+// an adjustable limit.
+type inFlightLimiter struct {
+	opts *MaxInFlightOptions
+
+	mu      sync.Mutex
+	active  int
+	limit   float64
+	latency time.Duration // moving average
+	normal  list.List
+	low     list.List
+}
+
+type inFlightWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// queued returns the number of requests waiting for a slot.
+func (l *inFlightLimiter) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.normal.Len() + l.low.Len()
+}
+
+func (l *inFlightLimiter) overloaded() bool {
+	return l.opts.Adaptive && l.latency > l.opts.TargetLatency
+}
+
+func (l *inFlightLimiter) acquire(req *http.Request, prio Priority) bool {
+	l.mu.Lock()
+	if l.active < int(l.limit) && l.normal.Len()+l.low.Len() == 0 {
+		l.active++
+		l.mu.Unlock()
+		return true
+	}
+	if l.normal.Len()+l.low.Len() >= l.opts.QueueSize || (prio == PriorityLow && l.overloaded()) {
+		l.mu.Unlock()
+		return false
+	}
+
+	queue := &l.normal
+	if prio == PriorityLow {
+		queue = &l.low
+	}
+	w := &inFlightWaiter{ready: make(chan struct{})}
+	elem := queue.PushBack(w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.opts.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return true
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		// The slot was handed over just as we gave up waiting.
+		return true
+	}
+	queue.Remove(elem)
+	return false
+}
+
+func (l *inFlightLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+
+	if l.opts.Adaptive {
+		if l.latency == 0 {
+			l.latency = latency
+		} else {
+			l.latency += (latency - l.latency) / 8
+		}
+		// Additive increase, multiplicative decrease.
+		if l.latency > l.opts.TargetLatency {
+			l.limit *= 0.9
+			if l.limit < float64(l.opts.MinLimit) {
+				l.limit = float64(l.opts.MinLimit)
+			}
+		} else if l.limit < float64(l.opts.Limit) {
+			l.limit += 1 / l.limit
+			if l.limit > float64(l.opts.Limit) {
+				l.limit = float64(l.opts.Limit)
+			}
+		}
+	}
+
+	for l.active < int(l.limit) {
+		queue := &l.normal
+		if queue.Len() == 0 {
+			queue = &l.low
+		}
+		front := queue.Front()
+		if front == nil {
+			return
+		}
+		w := queue.Remove(front).(*inFlightWaiter)
+		w.granted = true
+		l.active++
+		close(w.ready)
+	}
+}
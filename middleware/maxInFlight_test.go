@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+// inFlightServer returns a middleware stack whose /block requests hold their
+// slot until release is closed, and whose /slow requests take 10ms, along
+// with its limiter.
+func inFlightServer(opts MaxInFlightOptions) (h http.Handler, l *inFlightLimiter, started chan struct{}, release chan struct{}) {
+	started = make(chan struct{}, 10)
+	release = make(chan struct{})
+
+	mw, l := maxInFlight(opts)
+	i := interpose.New()
+	i.Use(mw)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/block":
+			started <- struct{}{}
+			<-release
+		case "/slow":
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	return i, l, started, release
+}
+
+// waitQueued waits until n requests are waiting in the limiter's queue.
+func waitQueued(t *testing.T, l *inFlightLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued requests but got %d", n, l.queued())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func serveAsync(h http.Handler, req *http.Request) chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		done <- recorder
+	}()
+	return done
+}
+
+func inFlightRequest(path string, prio Priority) *http.Request {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("X-Priority", map[Priority]string{PriorityLow: "low", PriorityCritical: "critical"}[prio])
+	return req
+}
+
+func requestPriority(req *http.Request) Priority {
+	switch req.Header.Get("X-Priority") {
+	case "low":
+		return PriorityLow
+	case "critical":
+		return PriorityCritical
+	}
+	return PriorityNormal
+}
+
+func Test_MaxInFlightQueueFull(t *testing.T) {
+	h, l, started, release := inFlightServer(MaxInFlightOptions{Limit: 1, QueueSize: 1, QueueTimeout: 5 * time.Second})
+
+	first := serveAsync(h, inFlightRequest("/block", PriorityNormal))
+	<-started
+	queued := serveAsync(h, inFlightRequest("/", PriorityNormal))
+	waitQueued(t, l, 1)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, inFlightRequest("/", PriorityNormal))
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 503 with Retry-After when the queue is full but got %d %v", recorder.Code, recorder.Header())
+	}
+
+	close(release)
+	if r := <-first; r.Code != http.StatusOK {
+		t.Errorf("Expected the active request to succeed but got %d", r.Code)
+	}
+	if r := <-queued; r.Code != http.StatusOK {
+		t.Errorf("Expected the queued request to be served but got %d", r.Code)
+	}
+}
+
+func Test_MaxInFlightQueueTimeout(t *testing.T) {
+	h, _, started, release := inFlightServer(MaxInFlightOptions{Limit: 1, QueueSize: 1, QueueTimeout: 20 * time.Millisecond})
+	defer close(release)
+
+	serveAsync(h, inFlightRequest("/block", PriorityNormal))
+	<-started
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, inFlightRequest("/", PriorityNormal))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after the queue timeout but got %d", recorder.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the request to wait in the queue but it returned after %v", elapsed)
+	}
+}
+
+func Test_MaxInFlightCancel(t *testing.T) {
+	h, l, started, release := inFlightServer(MaxInFlightOptions{Limit: 1, QueueSize: 1, QueueTimeout: 10 * time.Second})
+	defer close(release)
+
+	serveAsync(h, inFlightRequest("/block", PriorityNormal))
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := serveAsync(h, inFlightRequest("/", PriorityNormal).WithContext(ctx))
+	waitQueued(t, l, 1)
+	cancel()
+
+	select {
+	case r := <-queued:
+		if r.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected a cancelled request to be rejected but got %d", r.Code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a cancelled request to leave the queue")
+	}
+
+	// The cancelled request must have given up its place in the queue.
+	if n := l.queued(); n != 0 {
+		t.Errorf("Expected the cancelled request to leave the queue but %d remain", n)
+	}
+	queued = serveAsync(h, inFlightRequest("/", PriorityNormal))
+	waitQueued(t, l, 1)
+	select {
+	case r := <-queued:
+		t.Errorf("Expected the request to be queued but got %d", r.Code)
+	default:
+	}
+}
+
+func Test_MaxInFlightCritical(t *testing.T) {
+	h, _, started, release := inFlightServer(MaxInFlightOptions{Limit: 1, Priority: requestPriority})
+	defer close(release)
+
+	serveAsync(h, inFlightRequest("/block", PriorityNormal))
+	<-started
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, inFlightRequest("/", PriorityNormal))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a normal request over the limit to be rejected but got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, inFlightRequest("/", PriorityCritical))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected a critical request to bypass the limit but got %d", recorder.Code)
+	}
+}
+
+func Test_MaxInFlightShedLow(t *testing.T) {
+	h, l, started, release := inFlightServer(MaxInFlightOptions{
+		Limit:         1,
+		QueueSize:     5,
+		QueueTimeout:  5 * time.Second,
+		Adaptive:      true,
+		TargetLatency: time.Millisecond,
+		Priority:      requestPriority,
+	})
+
+	// A slow request pushes the average latency above the target.
+	h.ServeHTTP(httptest.NewRecorder(), inFlightRequest("/slow", PriorityNormal))
+
+	first := serveAsync(h, inFlightRequest("/block", PriorityNormal))
+	<-started
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, inFlightRequest("/", PriorityLow))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a low priority request to be shed when overloaded but got %d", recorder.Code)
+	}
+
+	queued := serveAsync(h, inFlightRequest("/", PriorityNormal))
+	waitQueued(t, l, 1)
+	close(release)
+	<-first
+	if r := <-queued; r.Code != http.StatusOK {
+		t.Errorf("Expected a normal request to be queued and served but got %d", r.Code)
+	}
+}
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the request
+// duration histogram. They match the Prometheus client defaults.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the response size
+// histogram.
+var DefaultSizeBuckets = []float64{100, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8}
+
+// MetricsOptions configures a Metrics collector.
+type MetricsOptions struct {
+	// Namespace prefixes the metric names. Defaults to "http".
+	Namespace string
+
+	// Route returns the route label of the request, such as "/users/{id}",
+	// when the router has not recorded one with SetRoute. It is called after
+	// the request has been served, but sees the request as it was before
+	// routing. Routes should have a small number of distinct values.
+	Route func(*http.Request) string
+
+	// DurationBuckets and SizeBuckets override DefaultDurationBuckets and
+	// DefaultSizeBuckets.
+	DurationBuckets []float64
+	SizeBuckets     []float64
+}
+
+// Metrics records request count, requests in flight, latency and response
+// size by method, status class and route. Its Handler method is the
+// middleware, and Metrics itself is an http.Handler that serves the metrics
+// in the Prometheus text exposition format:
+//
+//	metrics := middleware.NewMetrics(middleware.MetricsOptions{})
+//	middle.Use(metrics.Handler)
+//	router.Handle("/metrics", metrics)
+type Metrics struct {
+	opts     MetricsOptions
+	inFlight atomic.Int64
+
+	mu     sync.Mutex
+	series map[metricLabels]*metricSeries
+}
+
+type metricLabels struct {
+	method, status, route string
+}
+
+type metricSeries struct {
+	count    uint64
+	duration histogram
+	size     histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(bounds))
+	}
+	for i, b := range bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+}
+
+// NewMetrics returns an empty Metrics collector.
+func NewMetrics(opts MetricsOptions) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "http"
+	}
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = DefaultDurationBuckets
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = DefaultSizeBuckets
+	}
+	opts.DurationBuckets = append([]float64(nil), opts.DurationBuckets...)
+	opts.SizeBuckets = append([]float64(nil), opts.SizeBuckets...)
+	sort.Float64s(opts.DurationBuckets)
+	sort.Float64s(opts.SizeBuckets)
+	return &Metrics{opts: opts, series: make(map[metricLabels]*metricSeries)}
+}
+
+// Handler counts and times the requests served by next and records the
+// size of their responses.
+func (m *Metrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		m.inFlight.Add(1)
+		start := time.Now()
+		req = withRouteHolder(req)
+		sw := &statusWriter{wrappedWriter: wrappedWriter{res}}
+		defer func() {
+			m.inFlight.Add(-1)
+			labels := metricLabels{
+				method: metricMethod(req.Method),
+				status: strconv.Itoa(sw.Status()/100) + "xx",
+				route:  routeOf(req, m.opts.Route),
+			}
+			m.observe(labels, time.Since(start), sw.bytes)
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
+
+func (m *Metrics) observe(labels metricLabels, d time.Duration, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[labels]
+	if !ok {
+		s = &metricSeries{}
+		m.series[labels] = s
+	}
+	s.count++
+	s.duration.observe(m.opts.DurationBuckets, d.Seconds())
+	s.size.observe(m.opts.SizeBuckets, float64(size))
+}
+
+// metricMethod bounds the cardinality of the method label.
+func metricMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	keys := make([]metricLabels, 0, len(m.series))
+	snapshot := make(map[metricLabels]metricSeries, len(m.series))
+	for k, s := range m.series {
+		keys = append(keys, k)
+		snapshot[k] = metricSeries{
+			count:    s.count,
+			duration: histogram{counts: append([]uint64(nil), s.duration.counts...), sum: s.duration.sum},
+			size:     histogram{counts: append([]uint64(nil), s.size.counts...), sum: s.size.sum},
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.status < b.status
+	})
+
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := bufio.NewWriter(res)
+	defer w.Flush()
+	ns := m.opts.Namespace
+
+	writeMeta(w, ns+"_requests_total", "counter", "Total number of HTTP requests.")
+	for _, k := range keys {
+		writeSample(w, ns+"_requests_total", k.String(), float64(snapshot[k].count))
+	}
+
+	writeMeta(w, ns+"_requests_in_flight", "gauge", "Number of HTTP requests currently being served.")
+	writeSample(w, ns+"_requests_in_flight", "", float64(m.inFlight.Load()))
+
+	writeMeta(w, ns+"_request_duration_seconds", "histogram", "Duration of HTTP requests in seconds.")
+	for _, k := range keys {
+		s := snapshot[k]
+		writeHistogram(w, ns+"_request_duration_seconds", k.String(), m.opts.DurationBuckets, s.duration, s.count)
+	}
+
+	writeMeta(w, ns+"_response_size_bytes", "histogram", "Size of HTTP response bodies in bytes.")
+	for _, k := range keys {
+		s := snapshot[k]
+		writeHistogram(w, ns+"_response_size_bytes", k.String(), m.opts.SizeBuckets, s.size, s.count)
+	}
+}
+
+func (k metricLabels) String() string {
+	return `method="` + escapeLabel(k.method) + `",route="` + escapeLabel(k.route) +
+		`",status="` + escapeLabel(k.status) + `"`
+}
+
+func writeMeta(w *bufio.Writer, name, typ, help string) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func writeHistogram(w *bufio.Writer, name, labels string, bounds []float64, h histogram, count uint64) {
+	for i, b := range bounds {
+		var c uint64
+		if h.counts != nil {
+			c = h.counts[i]
+		}
+		writeSample(w, name+"_bucket", labels+`,le="`+formatFloat(b)+`"`, float64(c))
+	}
+	writeSample(w, name+"_bucket", labels+`,le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_Metrics(t *testing.T) {
+	metrics := NewMetrics(MetricsOptions{
+		DurationBuckets: []float64{1, 0.5},
+		SizeBuckets:     []float64{10, 100},
+	})
+
+	i := interpose.New()
+	i.Use(metrics.Handler)
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SetRoute(req, "/users/{id}")
+		if req.URL.Path == "/users/quote" {
+			SetRoute(req, `/users/"quoted"`)
+		}
+		w.Write([]byte(strings.Repeat("x", 50)))
+	}))
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/quote"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		i.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	metrics.ServeHTTP(recorder, req)
+	out := recorder.Body.String()
+
+	for _, line := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="/users/\"quoted\"",status="2xx"} 1`,
+		"http_requests_in_flight 0",
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="0.5"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="2xx",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="2xx",le="10"} 0`,
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="2xx",le="100"} 2`,
+		`http_response_size_bytes_bucket{method="GET",route="/users/{id}",status="2xx",le="+Inf"} 2`,
+		`http_response_size_bytes_sum{method="GET",route="/users/{id}",status="2xx"} 100`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected the exposition to contain %q", line)
+		}
+	}
+	if strings.Index(out, `le="0.5"`) > strings.Index(out, `le="1"`) {
+		t.Error("Expected the buckets to be sorted")
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"github.com/phyber/negroni-gzip/gzip"
 )
 
+// NegroniGzip wraps the negroni-gzip middleware.
+//
+// Deprecated: use Compress, which does not depend on Negroni.
 func NegroniGzip(compression int) func(http.Handler) http.Handler {
 	return adaptors.FromNegroni(gzip.Gzip(compression))
 }
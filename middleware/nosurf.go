@@ -6,7 +6,8 @@ import (
 	"github.com/justinas/nosurf"
 )
 
-// Nosurf is a wrapper for justinas' csrf protection middleware
+// Nosurf is a wrapper for justinas' csrf protection middleware with its default
+// configuration. See CSRF for a configurable alternative.
 func Nosurf() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return nosurf.New(next)
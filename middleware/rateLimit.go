@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm selects how RateLimit counts requests.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Burst requests, refilled at a rate
+	// of Limit per Window.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any period of length Window,
+	// approximated from the counts of the current and previous windows.
+	SlidingWindow
+)
+
+// RateLimitPolicy is the limit that a RateLimitStore enforces for a key.
+type RateLimitPolicy struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Burst     int
+	Window    time.Duration
+}
+
+// RateLimitResult is the outcome of taking a request from a key's allowance.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time until the allowance is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until the next request will be allowed, if
+	// this one was not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of rate limited keys. Implementations must
+// be safe for concurrent use, and Take must be atomic per key.
+type RateLimitStore interface {
+	Take(key string, now time.Time, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// RateLimitOptions configures the RateLimit middleware.
+type RateLimitOptions struct {
+	// Limit is the number of requests allowed per Window.
+	Limit  int
+	Window time.Duration
+
+	// Burst is the capacity of the token bucket. Defaults to Limit.
+	Burst int
+
+	// Algorithm defaults to TokenBucket.
+	Algorithm RateLimitAlgorithm
+
+	// Key returns the key that requests are counted against. Requests for
+	// which it returns "" are not limited. Defaults to KeyByIP.
+	Key func(*http.Request) string
+
+	// Store defaults to a new in-memory store.
+	Store RateLimitStore
+
+	// Exceeded is called when a request is over the limit. If nil, a plain
+	// http.StatusTooManyRequests is written. The Retry-After header has
+	// already been set when it is called.
+	Exceeded http.Handler
+
+	// OnError, if set, is called when the store fails. The request is then
+	// allowed through.
+	OnError func(*http.Request, error)
+}
+
+// RateLimit returns a Handler that limits the rate of requests per key. Every
+// response carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and rejected requests a Retry-After header.
+func RateLimit(opts RateLimitOptions) func(http.Handler) http.Handler {
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		panic("middleware: RateLimit requires a positive Limit and Window")
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = opts.Limit
+	}
+	if opts.Key == nil {
+		opts.Key = KeyByIP
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+	if opts.Exceeded == nil {
+		opts.Exceeded = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "Too Many Requests", http.StatusTooManyRequests)
+		})
+	}
+	policy := RateLimitPolicy{
+		Algorithm: opts.Algorithm,
+		Limit:     opts.Limit,
+		Burst:     opts.Burst,
+		Window:    opts.Window,
+	}
+	limit := strconv.Itoa(opts.Limit)
+	if opts.Algorithm == TokenBucket {
+		limit = strconv.Itoa(opts.Burst)
+	}
+	policyHeader := strconv.Itoa(opts.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(opts.Window.Seconds())))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			key := opts.Key(req)
+			if key == "" {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			result, err := opts.Store.Take(key, time.Now(), policy)
+			if err != nil {
+				if opts.OnError != nil {
+					opts.OnError(req, err)
+				}
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			h := res.Header()
+			h.Set("RateLimit-Policy", policyHeader)
+			h.Set("RateLimit-Limit", limit)
+			h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			h.Set("RateLimit-Reset", headerSeconds(result.Reset))
+			if !result.Allowed {
+				h.Set("Retry-After", headerSeconds(result.RetryAfter))
+				opts.Exceeded.ServeHTTP(res, req)
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// headerSeconds formats d as a whole number of seconds, rounded up, for
+// headers such as Retry-After.
+func headerSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// KeyByIP keys requests by the IP address of the client, as resolved by
+// RealIP if it is in use.
+func KeyByIP(req *http.Request) string {
+	return ClientIP(req)
+}
+
+// KeyByUser keys requests by the User stored by BasicAuth. Anonymous
+// requests are not limited.
+func KeyByUser(req *http.Request) string {
+	user, _ := GetUser(req)
+	return string(user)
+}
+
+// KeyByHeader returns a key function that keys requests by the value of the
+// named header, such as an API key. Requests without it are not limited.
+func KeyByHeader(name string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+const rateLimitShards = 64
+
+// MemoryRateLimitStore is a RateLimitStore that keeps its state in memory,
+// sharded by key to reduce lock contention.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+	sweeper sweeper
+}
+
+type rateLimitEntry struct {
+	// Token bucket state.
+	tokens float64
+	last   time.Time
+
+	// Sliding window state.
+	window int64
+	count  int
+	prev   int
+}
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Take satisfies the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, now time.Time, p RateLimitPolicy) (RateLimitResult, error) {
+	shard := &s.shards[rateLimitShardOf(key)]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Every so often, drop keys that have been idle long enough to have
+	// their full allowance back, so that recreating them grants nothing
+	// extra.
+	idle := p.restoreTime()
+	sweep(&shard.sweeper, shard.entries, func(e *rateLimitEntry) bool { return now.Sub(e.last) >= idle })
+
+	e, ok := shard.entries[key]
+	if !ok {
+		e = &rateLimitEntry{tokens: float64(p.Burst), last: now}
+		shard.entries[key] = e
+	}
+
+	if p.Algorithm == SlidingWindow {
+		return e.slidingWindow(now, p), nil
+	}
+	return e.tokenBucket(now, p), nil
+}
+
+// rateLimitShardOf returns the index of the shard that holds key.
+func rateLimitShardOf(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % rateLimitShards
+}
+
+// restoreTime returns how long a key must be idle to have its full allowance
+// back, however much of it was used: the time to refill an empty bucket, or
+// for the current window to slide out of view.
+func (p RateLimitPolicy) restoreTime() time.Duration {
+	if p.Algorithm == SlidingWindow {
+		return 2 * p.Window
+	}
+	refill := time.Duration(float64(p.Burst) / float64(p.Limit) * float64(p.Window))
+	return max(p.Window, refill)
+}
+
+func (e *rateLimitEntry) tokenBucket(now time.Time, p RateLimitPolicy) RateLimitResult {
+	rate := float64(p.Limit) / p.Window.Seconds()
+	if elapsed := now.Sub(e.last).Seconds(); elapsed > 0 {
+		e.tokens = math.Min(float64(p.Burst), e.tokens+elapsed*rate)
+	}
+	e.last = now
+
+	var r RateLimitResult
+	if e.tokens >= 1 {
+		e.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration((1 - e.tokens) / rate * float64(time.Second))
+	}
+	r.Remaining = int(e.tokens)
+	r.Reset = time.Duration((float64(p.Burst) - e.tokens) / rate * float64(time.Second))
+	return r
+}
+
+func (e *rateLimitEntry) slidingWindow(now time.Time, p RateLimitPolicy) RateLimitResult {
+	window := now.UnixNano() / int64(p.Window)
+	switch window - e.window {
+	case 0:
+	case 1:
+		e.prev, e.count = e.count, 0
+	default:
+		e.prev, e.count = 0, 0
+	}
+	e.window = window
+	e.last = now
+
+	elapsed := time.Duration(now.UnixNano() - window*int64(p.Window))
+	untilNext := p.Window - elapsed
+	weight := 1 - float64(elapsed)/float64(p.Window)
+	estimate := float64(e.prev)*weight + float64(e.count)
+
+	var r RateLimitResult
+	if estimate+1 <= float64(p.Limit) {
+		e.count++
+		r.Allowed = true
+		r.Remaining = int(float64(p.Limit) - estimate - 1)
+	}
+
+	// The allowance is fully restored once the requests counted in the
+	// current window, if any, have slid out of view at the end of the next
+	// one, and otherwise once the previous window has at the end of this
+	// one.
+	switch {
+	case e.count > 0:
+		r.Reset = untilNext + p.Window
+	case e.prev > 0:
+		r.Reset = untilNext
+	}
+	if r.Allowed {
+		return r
+	}
+
+	// Find when enough of the previous window has slid out of view to
+	// admit another request.
+	r.RetryAfter = untilNext
+	if e.count+1 <= p.Limit && e.prev > 0 {
+		needed := 1 - float64(p.Limit-e.count-1)/float64(e.prev)
+		r.RetryAfter = time.Duration(needed*float64(p.Window)) - elapsed
+	}
+	return r
+}
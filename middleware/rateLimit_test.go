@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_RateLimit(t *testing.T) {
+	i := interpose.New()
+	i.Use(RateLimit(RateLimitOptions{Limit: 2, Window: time.Minute}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	for n, want := range []int{200, 200, 429} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		i.ServeHTTP(recorder, r)
+
+		if recorder.Code != want {
+			t.Errorf("request %d: got %d wanted %d", n, recorder.Code, want)
+		}
+		if got := recorder.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit %q", n, got)
+		}
+		if want == 429 && recorder.Header().Get("Retry-After") != "30" {
+			t.Errorf("request %d: Retry-After %q", n, recorder.Header().Get("Retry-After"))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.2:1234"
+	i.ServeHTTP(recorder, r)
+	if recorder.Code != 200 {
+		t.Errorf("other client: got %d", recorder.Code)
+	}
+}
+
+func Test_MemoryRateLimitStoreSlidingWindow(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	p := RateLimitPolicy{Algorithm: SlidingWindow, Limit: 10, Window: time.Minute}
+	start := time.Unix(0, 0)
+
+	for n := 0; n < 10; n++ {
+		if r, _ := s.Take("k", start, p); !r.Allowed {
+			t.Fatalf("request %d not allowed", n)
+		}
+	}
+	if r, _ := s.Take("k", start.Add(30*time.Second), p); r.Allowed || r.RetryAfter != 30*time.Second {
+		t.Errorf("over limit: %+v", r)
+	}
+
+	// Halfway into the next window, half of the previous window's requests
+	// still count.
+	for n := 0; n < 5; n++ {
+		if r, _ := s.Take("k", start.Add(90*time.Second), p); !r.Allowed {
+			t.Fatalf("next window request %d not allowed", n)
+		}
+	}
+	if r, _ := s.Take("k", start.Add(90*time.Second), p); r.Allowed || r.RetryAfter != 6*time.Second {
+		t.Errorf("next window over limit: %+v", r)
+	}
+}
+
+func Test_MemoryRateLimitStoreSlidingWindowReset(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	p := RateLimitPolicy{Algorithm: SlidingWindow, Limit: 10, Window: time.Minute}
+	start := time.Unix(0, 0)
+
+	// A request counted in this window weighs on the next one too.
+	if r, _ := s.Take("k", start.Add(15*time.Second), p); r.Reset != 105*time.Second {
+		t.Errorf("Expected the allowance back at the end of the next window but got %v", r.Reset)
+	}
+	if r, _ := s.Take("k", start.Add(75*time.Second), p); r.Reset != 105*time.Second {
+		t.Errorf("Expected the allowance back at the end of the next window but got %v", r.Reset)
+	}
+	if r, _ := s.Take("fresh", start.Add(15*time.Second), p); r.Reset != 105*time.Second || r.Remaining != 9 {
+		t.Errorf("Expected a fresh key to be reset by the end of the next window but got %+v", r)
+	}
+}
+
+func Test_MemoryRateLimitStorePrune(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	p := RateLimitPolicy{Algorithm: TokenBucket, Limit: 1, Burst: 10, Window: time.Second}
+	start := time.Unix(0, 0)
+
+	for n := 0; n < 10; n++ {
+		if r, _ := s.Take("victim", start, p); !r.Allowed {
+			t.Fatalf("request %d not allowed", n)
+		}
+	}
+
+	// Sweep the victim's shard three seconds later, when its bucket holds
+	// three tokens: it must not be pruned and refilled.
+	later := start.Add(3 * time.Second)
+	shard := rateLimitShardOf("victim")
+	for n, swept := 0, 0; swept < sweepInterval; n++ {
+		key := "other" + strconv.Itoa(n)
+		if rateLimitShardOf(key) == shard {
+			s.Take(key, later, p)
+			swept++
+		}
+	}
+
+	var allowed int
+	for n := 0; n < 10; n++ {
+		if r, _ := s.Take("victim", later, p); r.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 refilled tokens but %d requests were allowed", allowed)
+	}
+}
@@ -0,0 +1,269 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientInfo describes the client of a request as seen by the outermost
+// trusted proxy.
+type ClientInfo struct {
+	IP     netip.Addr
+	Scheme string
+	Host   string
+}
+
+// RealIPOptions configures the RealIP middleware.
+type RealIPOptions struct {
+	// TrustedProxies lists the CIDRs (or bare addresses) of the proxies whose
+	// forwarding headers are believed. Headers from other peers are ignored.
+	TrustedProxies []string
+
+	// RewriteRemoteAddr replaces RemoteAddr with the resolved client IP on the
+	// request passed down, for the benefit of handlers that are unaware of
+	// this middleware.
+	RewriteRemoteAddr bool
+}
+
+// RealIP returns a Handler that resolves the real client IP, scheme and host
+// of requests that arrive through trusted proxies, from the RFC 7239
+// Forwarded header or, failing that, the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host and X-Real-IP headers. The result is stored in the request
+// context and can be retrieved with GetClientInfo or ClientIP.
+//
+// RealIP panics if a trusted proxy cannot be parsed.
+func RealIP(opts RealIPOptions) func(http.Handler) http.Handler {
+	trusted := make([]netip.Prefix, 0, len(opts.TrustedProxies))
+	for _, s := range opts.TrustedProxies {
+		trusted = append(trusted, mustParsePrefix(s))
+	}
+	isTrusted := func(ip netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			info := resolveClient(req, isTrusted)
+			ctx := context.WithValue(req.Context(), clientInfoKey, info)
+			r := req.WithContext(ctx)
+			if opts.RewriteRemoteAddr && info.IP.IsValid() {
+				port := "0"
+				if _, p, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+					port = p
+				}
+				r.RemoteAddr = net.JoinHostPort(info.IP.String(), port)
+			}
+			next.ServeHTTP(res, r)
+		})
+	}
+}
+
+// GetClientInfo returns the client resolved by RealIP, if any.
+func GetClientInfo(req *http.Request) (*ClientInfo, bool) {
+	info, ok := req.Context().Value(clientInfoKey).(*ClientInfo)
+	return info, ok
+}
+
+// ClientIP returns the IP address of the client: the one resolved by RealIP
+// if it is in use, or else the host part of req.RemoteAddr.
+func ClientIP(req *http.Request) string {
+	if info, ok := GetClientInfo(req); ok && info.IP.IsValid() {
+		return info.IP.String()
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// forwardedHop is one proxy hop as described by forwarding headers.
+type forwardedHop struct {
+	ip    netip.Addr
+	proto string
+	host  string
+}
+
+func resolveClient(req *http.Request, isTrusted func(netip.Addr) bool) *ClientInfo {
+	info := &ClientInfo{Scheme: "http", Host: req.Host}
+	if req.TLS != nil {
+		info.Scheme = "https"
+	}
+	peer, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		if ip, err := netip.ParseAddr(req.RemoteAddr); err == nil {
+			info.IP = ip.Unmap()
+		}
+		return info
+	}
+	info.IP = peer.Addr().Unmap()
+	if !isTrusted(info.IP) {
+		return info
+	}
+
+	hops := forwardedHops(req)
+	// Walk from the nearest hop outwards, stopping at the first address that
+	// is not a trusted proxy: that is the client.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if !hop.ip.IsValid() {
+			break
+		}
+		info.IP = hop.ip
+		if hop.proto == "http" || hop.proto == "https" {
+			info.Scheme = hop.proto
+		}
+		if hop.host != "" {
+			info.Host = hop.host
+		}
+		if !isTrusted(hop.ip) {
+			break
+		}
+	}
+	return info
+}
+
+func forwardedHops(req *http.Request) []forwardedHop {
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(strings.Join(values, ","))
+	}
+
+	var hops []forwardedHop
+	if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, s := range strings.Split(strings.Join(values, ","), ",") {
+			hops = append(hops, forwardedHop{ip: parseHopAddr(s)})
+		}
+	} else if s := req.Header.Get("X-Real-IP"); s != "" {
+		hops = append(hops, forwardedHop{ip: parseHopAddr(s)})
+	}
+
+	// Proxies that append to X-Forwarded-Proto and X-Forwarded-Host do so
+	// alongside X-Forwarded-For, so when the lists line up each value
+	// belongs to the hop at the same position. Otherwise only the
+	// rightmost value, set by the nearest proxy, is used.
+	protos := forwardedList(req, "X-Forwarded-Proto")
+	hosts := forwardedList(req, "X-Forwarded-Host")
+	for i := range hops {
+		hops[i].proto = strings.ToLower(forwardedValue(protos, i, len(hops)))
+		hops[i].host = forwardedValue(hosts, i, len(hops))
+	}
+	return hops
+}
+
+func forwardedList(req *http.Request, name string) []string {
+	var list []string
+	for _, s := range strings.Split(strings.Join(req.Header.Values(name), ","), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// forwardedValue returns the value of list for hop i of n.
+func forwardedValue(list []string, i, n int) string {
+	switch {
+	case len(list) == 0:
+		return ""
+	case len(list) == n:
+		return list[i]
+	}
+	return list[len(list)-1]
+}
+
+// parseForwarded parses the elements of an RFC 7239 Forwarded header.
+// Elements are separated by commas and pairs by semicolons, except within
+// quoted values.
+func parseForwarded(value string) []forwardedHop {
+	var hops []forwardedHop
+	var hop forwardedHop
+	s := value
+	for {
+		if end := strings.IndexAny(s, "=;,"); end >= 0 && s[end] == '=' {
+			var v string
+			k := strings.TrimSpace(s[:end])
+			v, s = forwardedToken(s[end+1:])
+			switch strings.ToLower(k) {
+			case "for":
+				hop.ip = parseHopAddr(v)
+			case "proto":
+				hop.proto = strings.ToLower(v)
+			case "host":
+				hop.host = v
+			}
+		}
+
+		next := strings.IndexAny(s, ";,")
+		if next < 0 {
+			return append(hops, hop)
+		}
+		if s[next] == ',' {
+			hops = append(hops, hop)
+			hop = forwardedHop{}
+		}
+		s = s[next+1:]
+	}
+}
+
+// forwardedToken parses a token or quoted-string value at the start of s,
+// returning it along with the rest of s.
+func forwardedToken(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t")
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, ";,")
+		if end < 0 {
+			return strings.TrimSpace(s), ""
+		}
+		return strings.TrimSpace(s[:end]), s[end:]
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), ""
+}
+
+// parseHopAddr parses an address that may carry a port and, for IPv6,
+// brackets. Obfuscated identifiers and "unknown" yield the zero Addr.
+func parseHopAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap()
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// mustParsePrefix parses a CIDR, treating a bare address as a single-host
+// prefix.
+func mustParsePrefix(s string) netip.Prefix {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked()
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		panic("middleware: invalid CIDR or address " + s)
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen())
+}
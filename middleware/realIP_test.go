@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_RealIP(t *testing.T) {
+	var info *ClientInfo
+	var remoteAddr string
+	i := interpose.New()
+	i.Use(RealIP(RealIPOptions{
+		TrustedProxies:    []string{"10.0.0.0/8", "2001:db8::1"},
+		RewriteRemoteAddr: true,
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, _ = GetClientInfo(req)
+		remoteAddr = req.RemoteAddr
+	}))
+
+	for _, tt := range []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		ip      string
+		scheme  string
+		host    string
+	}{
+		{"untrusted peer", "192.0.2.1:1000", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.1", "http", "example.com"},
+		{"no headers", "10.0.0.1:1000", nil, "10.0.0.1", "http", "example.com"},
+		{"x-forwarded-for", "10.0.0.1:1000", map[string]string{
+			"X-Forwarded-For":   "203.0.113.9, 198.51.100.7, 10.1.1.1",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "www.example.com",
+		}, "198.51.100.7", "https", "www.example.com"},
+		{"x-forwarded-proto per hop", "10.0.0.1:1000", map[string]string{
+			"X-Forwarded-For":   "198.51.100.7, 10.1.1.1",
+			"X-Forwarded-Proto": "http, https",
+		}, "198.51.100.7", "http", "example.com"},
+		{"x-forwarded-proto rightmost", "10.0.0.1:1000", map[string]string{
+			"X-Forwarded-For":   "198.51.100.7",
+			"X-Forwarded-Proto": "https, http",
+		}, "198.51.100.7", "http", "example.com"},
+		{"invalid scheme", "10.0.0.1:1000", map[string]string{
+			"X-Forwarded-For":   "198.51.100.7",
+			"X-Forwarded-Proto": "javascript",
+		}, "198.51.100.7", "http", "example.com"},
+		{"x-real-ip", "10.0.0.1:1000", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7", "http", "example.com"},
+		{"forwarded", "[2001:db8::1]:443", map[string]string{
+			"Forwarded": `for="[2001:db8::cafe]:4711";proto=https;host=www.example.com, for=10.2.2.2`,
+		}, "2001:db8::cafe", "https", "www.example.com"},
+		{"obfuscated", "10.0.0.1:1000", map[string]string{"Forwarded": "for=_hidden, for=10.3.3.3"}, "10.3.3.3", "http", "example.com"},
+		{"quoted separators", "10.0.0.1:1000", map[string]string{
+			"Forwarded": `for=198.51.100.8;host="www.example.com;x,y", for="[2001:db8::1]:80"`,
+		}, "198.51.100.8", "http", "www.example.com;x,y"},
+		{"quoted escape", "10.0.0.1:1000", map[string]string{
+			"Forwarded": `for="198.51.100.9";host="www.\"example\".com";proto=https`,
+		}, "198.51.100.9", "https", `www."example".com`},
+	} {
+		r, _ := http.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		i.ServeHTTP(httptest.NewRecorder(), r)
+
+		if r.RemoteAddr != tt.remote {
+			t.Errorf("%s: caller's RemoteAddr changed to %q", tt.name, r.RemoteAddr)
+		}
+		if info == nil || info.IP.String() != tt.ip || info.Scheme != tt.scheme || info.Host != tt.host {
+			t.Errorf("%s: got %+v wanted %s %s %s", tt.name, info, tt.ip, tt.scheme, tt.host)
+		}
+		if host, _, _ := net.SplitHostPort(remoteAddr); host != tt.ip {
+			t.Errorf("%s: RemoteAddr %q not rewritten", tt.name, remoteAddr)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// RequestIDOptions configures the RequestID middleware.
+type RequestIDOptions struct {
+	// Header is the request and response header that carries the ID.
+	// Defaults to "X-Request-ID".
+	Header string
+
+	// IgnoreIncoming always generates a new ID, for services that face
+	// untrusted clients.
+	IgnoreIncoming bool
+
+	// Generate returns a new ID. Defaults to NewRequestID.
+	Generate func() string
+
+	// Validate reports whether an incoming ID is acceptable; invalid IDs are
+	// replaced by a generated one. Defaults to ValidRequestID.
+	Validate func(string) bool
+}
+
+// RequestID returns a Handler that assigns every request an ID, reusing a
+// valid incoming one so that it can be correlated across services. The ID is
+// stored in the request context, where logging middleware pick it up, and is
+// echoed in the response header.
+func RequestID(opts RequestIDOptions) func(http.Handler) http.Handler {
+	if opts.Header == "" {
+		opts.Header = "X-Request-ID"
+	}
+	if opts.Generate == nil {
+		opts.Generate = NewRequestID
+	}
+	if opts.Validate == nil {
+		opts.Validate = ValidRequestID
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(opts.Header)
+			if opts.IgnoreIncoming || !opts.Validate(id) {
+				id = opts.Generate()
+				req.Header.Set(opts.Header, id)
+			}
+			res.Header().Set(opts.Header, id)
+			ctx := context.WithValue(req.Context(), requestIDKey, id)
+			next.ServeHTTP(res, req.WithContext(ctx))
+		})
+	}
+}
+
+// GetRequestID returns the ID assigned by RequestID, or "" if there is none.
+func GetRequestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// ValidRequestID reports whether id is between 1 and 128 characters drawn
+// from letters, digits and "-_.:", which covers UUIDs, ULIDs and the IDs of
+// common load balancers while keeping log lines safe.
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID returns a random, time-ordered version 7 UUID.
+func NewRequestID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		panic(err)
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_ValidRequestID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"0190a0b2-7c3e-7def-8abc-0123456789ab", true},
+		{"1-67891233-abcdef012345678912345678", true},
+		{"a.b_c:d", true},
+		{strings.Repeat("a", 128), true},
+		{"", false},
+		{strings.Repeat("a", 129), false},
+		{"id with spaces", false},
+		{"id\nforged=1", false},
+		{"<script>", false},
+	}
+	for _, tt := range tests {
+		if ValidRequestID(tt.id) != tt.valid {
+			t.Errorf("Expected ValidRequestID(%q) to be %v", tt.id, tt.valid)
+		}
+	}
+}
+
+func Test_NewRequestID(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := NewRequestID()
+	if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+		t.Fatalf("Expected a UUID but got %q", id)
+	}
+	if id[14] != '7' {
+		t.Errorf("Expected version 7 but got %q", id)
+	}
+	if !strings.ContainsRune("89ab", rune(id[19])) {
+		t.Errorf("Expected the RFC 9562 variant but got %q", id)
+	}
+	if id == NewRequestID() {
+		t.Error("Expected distinct IDs")
+	}
+	ms, err := strconv.ParseInt(id[0:8]+id[9:13], 16, 64)
+	if err != nil || ms < before || ms > time.Now().UnixMilli() {
+		t.Errorf("Expected the ID to start with the current time but got %q", id)
+	}
+}
+
+func Test_RequestID(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		incoming string
+		ignore   bool
+		keep     bool
+	}{
+		{"none", "", false, false},
+		{"valid", "abc-123", false, true},
+		{"invalid", "bad id", false, false},
+		{"ignored", "abc-123", true, false},
+	} {
+		var seen string
+		i := interpose.New()
+		i.Use(RequestID(RequestIDOptions{IgnoreIncoming: tt.ignore}))
+		i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			seen = GetRequestID(req)
+		}))
+
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		i.ServeHTTP(recorder, req)
+
+		if got := recorder.Header().Get("X-Request-ID"); got != seen || !ValidRequestID(got) {
+			t.Errorf("%s: expected the response to echo the ID %q but got %q", tt.name, seen, got)
+		}
+		if (seen == tt.incoming) != tt.keep {
+			t.Errorf("%s: expected keeping the incoming ID to be %v but got %q", tt.name, tt.keep, seen)
+		}
+	}
+}
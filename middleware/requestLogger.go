@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// RequestLoggerOptions configures the RequestLogger middleware.
+type RequestLoggerOptions struct {
+	// Logger is the parent of the request loggers. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// RequestLogger returns a Handler that stores a logger in the request
+// context, retrieved with GetLogger, so that everything logged while serving
+// the request shares the same fields. The logger starts with the request ID
+// (if RequestID is added before RequestLogger), method and path, and gains
+// any attributes added later with AddLogAttrs, such as the user set by
+// BasicAuth.
+func RequestLogger(opts RequestLoggerOptions) func(http.Handler) http.Handler {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			attrs := []any{
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+			}
+			if id := GetRequestID(req); id != "" {
+				attrs = append(attrs, slog.String("request_id", id))
+			}
+			if user, ok := GetUser(req); ok {
+				attrs = append(attrs, slog.String("user", string(user)))
+			}
+			l := &requestLogger{logger: opts.Logger.With(attrs...)}
+			next.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), loggerKey, l)))
+		})
+	}
+}
+
+// GetLogger returns the logger of the request. Without RequestLogger it
+// returns slog.Default().
+func GetLogger(req *http.Request) *slog.Logger {
+	return LoggerFromContext(req.Context())
+}
+
+// LoggerFromContext returns the request logger stored in ctx, or
+// slog.Default() if there is none.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*requestLogger); ok {
+		return l.get()
+	}
+	return slog.Default()
+}
+
+// requestLogger holds the logger of a request. It is shared by the handlers
+// nested inside RequestLogger so that attributes added by one are seen by
+// the others.
+type requestLogger struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+func (l *requestLogger) with(attrs ...slog.Attr) {
+	args := make([]any, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger = l.logger.With(args...)
+}
+
+func (l *requestLogger) get() *slog.Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logger
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_RequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	i := interpose.New()
+	i.Use(RequestID(RequestIDOptions{}))
+	i.Use(RequestLogger(RequestLoggerOptions{Logger: logger}))
+	i.Use(BasicAuth("foo", "bar"))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		GetLogger(req).Info("hello")
+	}))
+
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("foo:bar")))
+	i.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"msg": "hello", "method": "GET", "path": "/foo", "user": "foo"} {
+		if record[k] != want {
+			t.Errorf("Expected %s to be %q but got %v", k, want, record[k])
+		}
+	}
+	if record["request_id"] == nil || record["request_id"] == "" {
+		t.Error("Expected the request ID to be logged")
+	}
+}
+
+func Test_GetLoggerDefault(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	if GetLogger(req) != slog.Default() {
+		t.Error("Expected the default logger without RequestLogger")
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// SetRoute records the route matched for the request, such as
+// "/users/{id}", so that Trace, Metrics and AccessLog can report it. Those
+// middleware run before the router, so the router has to hand the route
+// back; with gorilla/mux, for example:
+//
+//	router.Use(func(next http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+//			if route := mux.CurrentRoute(req); route != nil {
+//				tmpl, _ := route.GetPathTemplate()
+//				middleware.SetRoute(req, tmpl)
+//			}
+//			next.ServeHTTP(w, req)
+//		})
+//	})
+//
+// SetRoute does nothing if none of those middleware is in use.
+func SetRoute(req *http.Request, route string) {
+	if h, ok := req.Context().Value(routeKey).(*routeHolder); ok {
+		h.set(route)
+	}
+}
+
+// GetRoute returns the route recorded with SetRoute, or "" if there is none.
+func GetRoute(req *http.Request) string {
+	if h, ok := req.Context().Value(routeKey).(*routeHolder); ok {
+		return h.get()
+	}
+	return ""
+}
+
+// routeHolder carries the route from the router back out to the middleware
+// wrapping it.
+type routeHolder struct {
+	mu    sync.Mutex
+	route string
+}
+
+func (h *routeHolder) set(route string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.route = route
+}
+
+func (h *routeHolder) get() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.route
+}
+
+// withRouteHolder returns req with a place for SetRoute to record the
+// route, reusing one added by outer middleware.
+func withRouteHolder(req *http.Request) *http.Request {
+	if _, ok := req.Context().Value(routeKey).(*routeHolder); ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), routeKey, &routeHolder{}))
+}
+
+// routeOf returns the route recorded for the request, falling back to fn,
+// which sees only what is known before routing.
+func routeOf(req *http.Request, fn func(*http.Request) string) string {
+	if route := GetRoute(req); route != "" {
+		return route
+	}
+	if fn != nil {
+		return fn(req)
+	}
+	return ""
+}
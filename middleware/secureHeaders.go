@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSPNonce is a placeholder source that may be used in a CSP directive. It is
+// replaced by 'nonce-...' with a value that is generated for every request and
+// can be retrieved with GetCSPNonce.
+const CSPNonce = "'nonce'"
+
+// CSP builds a Content-Security-Policy header value. Directives are emitted in
+// the order in which they were first added.
+type CSP struct {
+	names   []string
+	sources map[string][]string
+}
+
+// NewCSP returns an empty policy.
+func NewCSP() *CSP {
+	return &CSP{sources: make(map[string][]string)}
+}
+
+// Add appends sources to the named directive and returns the policy so that
+// calls can be chained. A directive without sources, such as
+// "upgrade-insecure-requests", is emitted on its own.
+func (c *CSP) Add(directive string, sources ...string) *CSP {
+	if _, ok := c.sources[directive]; !ok {
+		c.names = append(c.names, directive)
+	}
+	c.sources[directive] = append(c.sources[directive], sources...)
+	return c
+}
+
+// usesNonce reports whether any directive contains the CSPNonce placeholder.
+func (c *CSP) usesNonce() bool {
+	for _, sources := range c.sources {
+		if contains(sources, CSPNonce) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns the policy as a header value, with the CSPNonce placeholder
+// replaced by nonce.
+func (c *CSP) Render(nonce string) string {
+	var b strings.Builder
+	for i, name := range c.names {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(name)
+		for _, src := range c.sources[name] {
+			if src == CSPNonce {
+				src = "'nonce-" + nonce + "'"
+			}
+			b.WriteString(" ")
+			b.WriteString(src)
+		}
+	}
+	return b.String()
+}
+
+// SecureHeadersOptions configures the SecureHeaders middleware. Headers whose
+// options are left at their zero value are not sent.
+type SecureHeadersOptions struct {
+	// HSTSMaxAge sets Strict-Transport-Security on TLS requests (or on all
+	// requests with ForceHSTS, for use behind a TLS terminating proxy).
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	ForceHSTS             bool
+
+	// FrameOptions sets X-Frame-Options, e.g. "DENY" or "SAMEORIGIN".
+	FrameOptions string
+
+	// ContentTypeNosniff sets X-Content-Type-Options: nosniff.
+	ContentTypeNosniff bool
+
+	// ReferrerPolicy sets Referrer-Policy, e.g. "strict-origin-when-cross-origin".
+	ReferrerPolicy string
+
+	// PermissionsPolicy sets Permissions-Policy, e.g. "geolocation=(), camera=()".
+	PermissionsPolicy string
+
+	// CrossOriginOpenerPolicy, CrossOriginEmbedderPolicy and
+	// CrossOriginResourcePolicy set the COOP, COEP and CORP headers.
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+
+	// ContentSecurityPolicy sets Content-Security-Policy, or
+	// Content-Security-Policy-Report-Only if CSPReportOnly is true.
+	ContentSecurityPolicy *CSP
+	CSPReportOnly         bool
+}
+
+// SecureHeaders returns a Handler that sets security related response headers.
+// Like any middleware that modifies headers, it must be added before
+// middleware that writes the response body.
+func SecureHeaders(opts SecureHeadersOptions) func(http.Handler) http.Handler {
+	var hsts string
+	if opts.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(opts.HSTSMaxAge/time.Second), 10)
+		if opts.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if opts.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	static := http.Header{}
+	set := func(name, value string) {
+		if value != "" {
+			static.Set(name, value)
+		}
+	}
+	set("X-Frame-Options", opts.FrameOptions)
+	if opts.ContentTypeNosniff {
+		set("X-Content-Type-Options", "nosniff")
+	}
+	set("Referrer-Policy", opts.ReferrerPolicy)
+	set("Permissions-Policy", opts.PermissionsPolicy)
+	set("Cross-Origin-Opener-Policy", opts.CrossOriginOpenerPolicy)
+	set("Cross-Origin-Embedder-Policy", opts.CrossOriginEmbedderPolicy)
+	set("Cross-Origin-Resource-Policy", opts.CrossOriginResourcePolicy)
+
+	cspHeader := "Content-Security-Policy"
+	if opts.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	csp := opts.ContentSecurityPolicy
+	nonced := csp != nil && csp.usesNonce()
+	if csp != nil && !nonced {
+		set(cspHeader, csp.Render(""))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			h := res.Header()
+			for name, values := range static {
+				h[name] = append([]string(nil), values...)
+			}
+			if hsts != "" && (req.TLS != nil || opts.ForceHSTS) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			if nonced {
+				nonce := newCSPNonce()
+				h.Set(cspHeader, csp.Render(nonce))
+				req = req.WithContext(context.WithValue(req.Context(), cspNonceKey, nonce))
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// GetCSPNonce returns the nonce generated by SecureHeaders for this request,
+// for use in the nonce attribute of inline script and style elements. It is
+// empty if the policy does not use CSPNonce.
+func GetCSPNonce(req *http.Request) string {
+	nonce, _ := req.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
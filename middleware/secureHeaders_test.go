@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_SecureHeaders(t *testing.T) {
+	var nonces, frameOptions []string
+
+	i := interpose.New()
+	i.Use(SecureHeaders(SecureHeadersOptions{
+		HSTSMaxAge:         time.Hour,
+		FrameOptions:       "DENY",
+		ContentTypeNosniff: true,
+		ContentSecurityPolicy: NewCSP().
+			Add("default-src", "'self'").
+			Add("script-src", "'self'", CSPNonce),
+	}))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nonces = append(nonces, GetCSPNonce(req))
+		frameOptions = append(frameOptions, w.Header().Get("X-Frame-Options"))
+		w.Header()["X-Frame-Options"][0] = "SAMEORIGIN"
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("Expected no HSTS header without TLS")
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Strict-Transport-Security") != "max-age=3600" {
+		t.Errorf("Expected HSTS over TLS but got %q", recorder.Header().Get("Strict-Transport-Security"))
+	}
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Errorf("Expected a fresh nonce per request but got %q", nonces)
+	}
+	csp := recorder.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "'nonce-"+nonces[1]+"'") {
+		t.Errorf("Expected the CSP to carry the nonce but got %q", csp)
+	}
+
+	// Handlers may change their own headers without affecting later
+	// responses.
+	if frameOptions[0] != "DENY" || frameOptions[1] != "DENY" {
+		t.Errorf("Expected every response to start with X-Frame-Options DENY but got %q", frameOptions)
+	}
+}
+
+func Test_SecureHeadersReportOnly(t *testing.T) {
+	i := interpose.New()
+	i.Use(SecureHeaders(SecureHeadersOptions{
+		ForceHSTS:             true,
+		HSTSMaxAge:            time.Hour,
+		ContentSecurityPolicy: NewCSP().Add("default-src", "'self'"),
+		CSPReportOnly:         true,
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	i.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Content-Security-Policy") != "" {
+		t.Error("Expected no enforcing CSP in report-only mode")
+	}
+	if recorder.Header().Get("Content-Security-Policy-Report-Only") != "default-src 'self'" {
+		t.Errorf("Expected a report-only CSP but got %v", recorder.Header())
+	}
+	if recorder.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("Expected ForceHSTS to send HSTS without TLS")
+	}
+}
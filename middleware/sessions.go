@@ -0,0 +1,450 @@
+package middleware
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionTooLarge is reported when an encoded session does not fit in a
+// cookie. Use a SessionStore for larger sessions.
+var ErrSessionTooLarge = errors.New("middleware: session too large for cookie")
+
+// maxCookieSize is the largest cookie value browsers can be relied upon to
+// store.
+const maxCookieSize = 4096
+
+// SessionOptions configures the Sessions middleware.
+type SessionOptions struct {
+	// Keys are the secrets used to sign and encrypt the session cookie. The
+	// first key is used to encode cookies; all of them are tried when
+	// decoding, so a new key can be rotated in by prepending it. At least one
+	// key is required.
+	Keys [][]byte
+
+	// Name is the name of the cookie. Defaults to "session".
+	Name string
+
+	// MaxAge is how long a session lives after it was last saved. Defaults to
+	// 24 hours.
+	MaxAge time.Duration
+
+	// Path and Domain scope the cookie. Path defaults to "/".
+	Path   string
+	Domain string
+
+	// Secure, HttpOnly and SameSite set the attributes of the same name on
+	// the cookie.
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+
+	// Store, if set, keeps the session data on the server. The cookie then
+	// only carries the (still signed and encrypted) session ID.
+	Store SessionStore
+
+	// OnError, if set, is called when a session cannot be saved.
+	OnError func(*http.Request, error)
+}
+
+// SessionStore keeps session data on the server for sessions that are too
+// large, or too sensitive, to live in a cookie.
+type SessionStore interface {
+	// Load returns the data saved for id, or nil if there is none.
+	Load(id string) ([]byte, error)
+	// Save stores data for id until expires.
+	Save(id string, data []byte, expires time.Time) error
+	// Delete removes the data for id.
+	Delete(id string) error
+}
+
+// Session holds the values of a session for the duration of a request.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	data      sessionData
+	dirty     bool
+	destroyed bool
+}
+
+type sessionData struct {
+	Values  map[string]string `json:"v,omitempty"`
+	Flashes []string          `json:"f,omitempty"`
+}
+
+// Get returns the value stored under key.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Values[key]
+}
+
+// Set stores value under key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]string)
+	}
+	s.data.Values[key] = value
+	s.dirty = true
+}
+
+// Delete removes the value stored under key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Values, key)
+	s.dirty = true
+}
+
+// AddFlash adds a message that is kept until it is read with Flashes.
+func (s *Session) AddFlash(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Flashes = append(s.data.Flashes, msg)
+	s.dirty = true
+}
+
+// Flashes returns and clears the pending flash messages.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.dirty = true
+	}
+	return flashes
+}
+
+// Destroy clears the session and expires its cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = sessionData{}
+	s.destroyed = true
+	s.dirty = true
+}
+
+// GetSession returns the Session loaded by the Sessions middleware. It
+// returns nil if the middleware is not in use.
+func GetSession(req *http.Request) *Session {
+	s, _ := req.Context().Value(sessionKey).(*Session)
+	return s
+}
+
+// Sessions returns a Handler that keeps session data in a signed and
+// encrypted cookie, or in opts.Store keyed by an ID held in such a cookie.
+// The session is available to later handlers through GetSession and is saved
+// when the response headers are written.
+func Sessions(opts SessionOptions) func(http.Handler) http.Handler {
+	if len(opts.Keys) == 0 {
+		panic("middleware: Sessions requires at least one key")
+	}
+	if opts.Name == "" {
+		opts.Name = "session"
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = 24 * time.Hour
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	codec := newSessionCodec(opts.Name, opts.Keys)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			s := &Session{}
+			if cookie, err := req.Cookie(opts.Name); err == nil {
+				opts.load(codec, s, cookie.Value)
+			}
+
+			sw := &sessionWriter{wrappedWriter: wrappedWriter{res}, save: func() {
+				opts.save(res, req, codec, s)
+			}}
+			ctx := context.WithValue(req.Context(), sessionKey, s)
+			next.ServeHTTP(sw, req.WithContext(ctx))
+			sw.commit()
+		})
+	}
+}
+
+func (opts *SessionOptions) load(codec *sessionCodec, s *Session, value string) {
+	plain, rotated, ok := codec.decode(value, opts.MaxAge)
+	if !ok {
+		return
+	}
+	if opts.Store != nil {
+		data, err := opts.Store.Load(string(plain))
+		if err != nil || data == nil {
+			return
+		}
+		s.id = string(plain)
+		plain = data
+	}
+	if json.Unmarshal(plain, &s.data) != nil {
+		s.data = sessionData{}
+		return
+	}
+	// Re-issue cookies encoded with an old key so they are migrated to the
+	// current one.
+	s.dirty = rotated
+}
+
+func (opts *SessionOptions) save(res http.ResponseWriter, req *http.Request, codec *sessionCodec, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     opts.Name,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   opts.Secure,
+		HttpOnly: opts.HttpOnly,
+		SameSite: opts.SameSite,
+	}
+
+	if s.destroyed {
+		if opts.Store != nil && s.id != "" {
+			if err := opts.Store.Delete(s.id); err != nil {
+				opts.fail(req, err)
+			}
+		}
+		cookie.MaxAge = -1
+		http.SetCookie(res, cookie)
+		return
+	}
+
+	plain, err := json.Marshal(s.data)
+	if err != nil {
+		opts.fail(req, err)
+		return
+	}
+	expires := time.Now().Add(opts.MaxAge)
+	if opts.Store != nil {
+		if s.id == "" {
+			s.id = newSessionID()
+		}
+		if err := opts.Store.Save(s.id, plain, expires); err != nil {
+			opts.fail(req, err)
+			return
+		}
+		plain = []byte(s.id)
+	}
+
+	value, err := codec.encode(plain)
+	if err != nil {
+		opts.fail(req, err)
+		return
+	}
+	if len(value) > maxCookieSize {
+		opts.fail(req, ErrSessionTooLarge)
+		return
+	}
+	cookie.Value = value
+	cookie.MaxAge = int(opts.MaxAge / time.Second)
+	cookie.Expires = expires
+	http.SetCookie(res, cookie)
+}
+
+func (opts *SessionOptions) fail(req *http.Request, err error) {
+	if opts.OnError != nil {
+		opts.OnError(req, err)
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sessionWriter saves the session just before the response headers are
+// written, which is the last moment a cookie can be set.
+type sessionWriter struct {
+	wrappedWriter
+	save      func()
+	committed bool
+}
+
+func (w *sessionWriter) commit() {
+	if !w.committed {
+		w.committed = true
+		w.save()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.commit()
+	w.wrappedWriter.Flush()
+}
+
+// sessionCodec encrypts values with AES-256-GCM and signs the result with
+// HMAC-SHA256. Separate encryption and signing keys are derived from each
+// configured key.
+type sessionCodec struct {
+	name string
+	keys []sessionKeys
+}
+
+type sessionKeys struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+func newSessionCodec(name string, keys [][]byte) *sessionCodec {
+	c := &sessionCodec{name: name}
+	for _, key := range keys {
+		enc := sha256.Sum256(append([]byte("interpose session encryption\x00"), key...))
+		mac := sha256.Sum256(append([]byte("interpose session signing\x00"), key...))
+		block, err := aes.NewCipher(enc[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		c.keys = append(c.keys, sessionKeys{aead: aead, mac: mac[:]})
+	}
+	return c
+}
+
+func (c *sessionCodec) encode(plain []byte) (string, error) {
+	k := c.keys[0]
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// The timestamp is authenticated as additional data so that cookies
+	// cannot be replayed past MaxAge.
+	payload := make([]byte, 8, 8+len(nonce)+len(plain)+k.aead.Overhead())
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, nonce...)
+	payload = k.aead.Seal(payload, nonce, plain, payload[:8])
+
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + base64.RawURLEncoding.EncodeToString(c.sign(k.mac, enc)), nil
+}
+
+func (c *sessionCodec) decode(value string, maxAge time.Duration) (plain []byte, rotated bool, ok bool) {
+	enc, sig, found := strings.Cut(value, ".")
+	if !found {
+		return nil, false, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, false, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, false, false
+	}
+
+	for i, k := range c.keys {
+		if !hmac.Equal(mac, c.sign(k.mac, enc)) {
+			continue
+		}
+		if len(payload) < 8+k.aead.NonceSize() {
+			return nil, false, false
+		}
+		issued := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+		if time.Since(issued) > maxAge {
+			return nil, false, false
+		}
+		nonce := payload[8 : 8+k.aead.NonceSize()]
+		plain, err := k.aead.Open(nil, nonce, payload[8+len(nonce):], payload[:8])
+		if err != nil {
+			return nil, false, false
+		}
+		return plain, i > 0, true
+	}
+	return nil, false, false
+}
+
+func (c *sessionCodec) sign(key []byte, enc string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(c.name + "|" + enc))
+	return h.Sum(nil)
+}
+
+// MemorySessionStore is a SessionStore that keeps sessions in memory. It is
+// suitable for a single process; sessions are lost when it restarts.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	sweeper  sweeper
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Load satisfies the SessionStore interface.
+func (m *MemorySessionStore) Load(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(s.expires) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+	return s.data, nil
+}
+
+// Save satisfies the SessionStore interface. Expired sessions are pruned
+// every so often as sessions are saved.
+func (m *MemorySessionStore) Save(id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	sweep(&m.sweeper, m.sessions, func(s memorySession) bool { return now.After(s.expires) })
+	m.sessions[id] = memorySession{data: data, expires: expires}
+	return nil
+}
+
+// Delete satisfies the SessionStore interface.
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func sessionMiddleware(opts SessionOptions) *interpose.Middleware {
+	i := interpose.New()
+	i.Use(Sessions(opts))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := GetSession(req)
+		switch req.URL.Path {
+		case "/set":
+			s.Set("name", req.URL.Query().Get("name"))
+			s.AddFlash("saved")
+		case "/destroy":
+			s.Destroy()
+		}
+		fmt.Fprintf(w, "%s %v", s.Get("name"), s.Flashes())
+	}))
+	return i
+}
+
+func sessionRequest(t *testing.T, h http.Handler, path string, cookie *http.Cookie) (string, *http.Cookie) {
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", path, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	h.ServeHTTP(recorder, r)
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) == 0 {
+		return recorder.Body.String(), nil
+	}
+	return recorder.Body.String(), cookies[0]
+}
+
+func Test_Sessions(t *testing.T) {
+	for _, store := range []SessionStore{nil, NewMemorySessionStore()} {
+		i := sessionMiddleware(SessionOptions{Keys: [][]byte{[]byte("key one")}, Store: store})
+
+		body, cookie := sessionRequest(t, i, "/set?name=foo", nil)
+		if body != "foo [saved]" || cookie == nil {
+			t.Fatalf("set: got %q, cookie %v", body, cookie)
+		}
+
+		body, _ = sessionRequest(t, i, "/", cookie)
+		if body != "foo []" {
+			t.Errorf("get: got %q", body)
+		}
+
+		cookie.Value = "x" + cookie.Value
+		body, _ = sessionRequest(t, i, "/", cookie)
+		if body != " []" {
+			t.Errorf("tampered cookie accepted: got %q", body)
+		}
+	}
+}
+
+func Test_SessionsKeyRotation(t *testing.T) {
+	old := sessionMiddleware(SessionOptions{Keys: [][]byte{[]byte("old")}})
+	_, cookie := sessionRequest(t, old, "/set?name=foo", nil)
+
+	rotated := sessionMiddleware(SessionOptions{Keys: [][]byte{[]byte("new"), []byte("old")}})
+	body, reissued := sessionRequest(t, rotated, "/", cookie)
+	if body != "foo []" || reissued == nil {
+		t.Fatalf("rotation: got %q, cookie %v", body, reissued)
+	}
+
+	current := sessionMiddleware(SessionOptions{Keys: [][]byte{[]byte("new")}})
+	if body, _ = sessionRequest(t, current, "/", reissued); body != "foo []" {
+		t.Errorf("reissued cookie not readable with new key: got %q", body)
+	}
+	if body, _ = sessionRequest(t, current, "/", cookie); body != " []" {
+		t.Errorf("retired key still accepted: got %q", body)
+	}
+}
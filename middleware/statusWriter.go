@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// statusWriter wraps an http.ResponseWriter to record the status code and the
+// number of body bytes written, for middleware that report on responses.
+type statusWriter struct {
+	wrappedWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status code of the response, which is
+// http.StatusOK if the handler never called WriteHeader.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.wrappedWriter.Flush()
+}
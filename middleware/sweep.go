@@ -0,0 +1,27 @@
+package middleware
+
+// sweepInterval is the number of operations on an in-memory store between
+// scans for expired entries.
+const sweepInterval = 1024
+
+// sweeper amortizes the cleanup of in-memory stores: instead of scanning
+// for expired entries on every operation, a store calls sweep each time and
+// the whole map is scanned once every sweepInterval calls. It must be
+// guarded by the same lock as the map.
+type sweeper struct {
+	ops int
+}
+
+// sweep deletes the entries of m for which expired returns true, once every
+// sweepInterval calls.
+func sweep[K comparable, V any](s *sweeper, m map[K]V, expired func(V) bool) {
+	if s.ops++; s.ops < sweepInterval {
+		return
+	}
+	s.ops = 0
+	for k, v := range m {
+		if expired(v) {
+			delete(m, k)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import "testing"
+
+func Test_Sweep(t *testing.T) {
+	var s sweeper
+	m := map[string]int{"a": 1, "b": 2, "c": 3}
+	odd := func(v int) bool { return v%2 == 1 }
+
+	for n := 1; n < sweepInterval; n++ {
+		sweep(&s, m, odd)
+	}
+	if len(m) != 3 {
+		t.Fatalf("Expected no sweep before %d calls but %d entries remain", sweepInterval, len(m))
+	}
+	sweep(&s, m, odd)
+	if len(m) != 1 || m["b"] != 2 {
+		t.Errorf("Expected only the unexpired entry to remain but got %v", m)
+	}
+	sweep(&s, m, func(int) bool { return true })
+	if len(m) != 1 {
+		t.Error("Expected the count to restart after a sweep")
+	}
+}
@@ -0,0 +1,296 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID identify traces and spans as in W3C Trace Context.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanKind describes the role of a span, using the OpenTelemetry values.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+)
+
+// Span is a timed operation within a trace.
+type Span struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	TraceState string
+	Sampled    bool
+	Kind       SpanKind
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Error      bool
+
+	mu         sync.Mutex
+	attributes map[string]any
+	exporter   SpanExporter
+	ended      bool
+}
+
+// SetAttribute records an attribute on the span. Values should be strings,
+// bools, integers or floats.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]any)
+	}
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the attributes recorded on the span.
+func (s *Span) Attributes() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]any, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// Finish ends the span and, if it is sampled, exports it. Calls after the
+// first have no effect.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Sampled && s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+// traceparent renders the span context as a traceparent header value.
+func (s *Span) traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-" + flags
+}
+
+// SpanExporter receives spans as they finish.
+type SpanExporter interface {
+	Export(*Span)
+}
+
+// TraceOptions configures the Trace middleware.
+type TraceOptions struct {
+	// Exporter receives the finished, sampled spans.
+	Exporter SpanExporter
+
+	// Route returns the route of the request, such as "/users/{id}", used
+	// in the span name and the http.route attribute when the router has not
+	// recorded one with SetRoute. It is called after the request has been
+	// served, but sees the request as it was before routing.
+	Route func(*http.Request) string
+
+	// Sample decides whether traces started by this service (that is,
+	// requests without a traceparent) are sampled. Defaults to always.
+	// Incoming traces keep the decision of their parent.
+	Sample func(*http.Request) bool
+}
+
+// Trace returns a Handler that records a server span for every request,
+// continuing the trace described by an incoming W3C traceparent header or
+// starting a new one. The span is stored in the request context, where
+// TraceLayer and StartSpan find it, and its context can be propagated to
+// outgoing requests with InjectTraceContext.
+//
+// Trace does not see the other layers of the stack, so it records no spans
+// for them by itself: wrap each layer that should get a child span with
+// TraceLayer.
+func Trace(opts TraceOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			span := &Span{Kind: SpanKindServer, Start: time.Now(), exporter: opts.Exporter}
+			if parent, ok := parseTraceparent(req.Header.Get("traceparent")); ok {
+				span.TraceID = parent.TraceID
+				span.ParentID = parent.SpanID
+				span.Sampled = parent.Sampled
+				span.TraceState = parseTracestate(req.Header.Values("tracestate"))
+			} else {
+				span.TraceID = newTraceID()
+				span.Sampled = opts.Sample == nil || opts.Sample(req)
+			}
+			span.SpanID = newSpanID()
+
+			span.Name = req.Method
+			span.SetAttribute("http.request.method", req.Method)
+			span.SetAttribute("url.path", req.URL.Path)
+
+			req = withRouteHolder(req)
+			sw := &statusWriter{wrappedWriter: wrappedWriter{res}}
+			defer func() {
+				if route := routeOf(req, opts.Route); route != "" {
+					span.Name = req.Method + " " + route
+					span.SetAttribute("http.route", route)
+				}
+				status := sw.Status()
+				span.SetAttribute("http.response.status_code", status)
+				span.SetAttribute("http.server.request.duration", time.Since(span.Start).Seconds())
+				span.Error = status >= 500
+				span.Finish()
+			}()
+
+			ctx := context.WithValue(req.Context(), spanKey, span)
+			next.ServeHTTP(sw, req.WithContext(ctx))
+		})
+	}
+}
+
+// GetSpan returns the innermost span of the request, if any.
+func GetSpan(req *http.Request) *Span {
+	return SpanFromContext(req.Context())
+}
+
+// SpanFromContext returns the innermost span stored in ctx, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the innermost span of the request and returns
+// it with a request carrying it. The caller must call Finish on the span. If
+// the request is not traced, the span is not exported.
+func StartSpan(req *http.Request, name string) (*Span, *http.Request) {
+	span := &Span{Kind: SpanKindInternal, Name: name, Start: time.Now(), SpanID: newSpanID()}
+	if parent := GetSpan(req); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.TraceState = parent.TraceState
+		span.Sampled = parent.Sampled
+		span.exporter = parent.exporter
+	}
+	ctx := context.WithValue(req.Context(), spanKey, span)
+	return span, req.WithContext(ctx)
+}
+
+// TraceLayer wraps a piece of middleware so that a child span named name is
+// recorded around it, covering the layer and everything nested within it.
+// It must be used inside the Trace middleware.
+//
+//	middle.Use(middleware.Trace(opts))
+//	middle.Use(middleware.TraceLayer("auth", middleware.BasicAuth("john", "doe")))
+func TraceLayer(name string, layer func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := layer(next)
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			span, req := StartSpan(req, name)
+			defer span.Finish()
+			span.SetAttribute("interpose.layer", name)
+			inner.ServeHTTP(res, req)
+		})
+	}
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of an
+// outgoing request so that the trace continues in the called service.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil || !span.TraceID.IsValid() {
+		return
+	}
+	h.Set("traceparent", span.traceparent())
+	if span.TraceState != "" {
+		h.Set("tracestate", span.TraceState)
+	}
+}
+
+// parseTraceparent parses a version 00 traceparent header. Later versions
+// are parsed as version 00, as the specification requires.
+func parseTraceparent(value string) (*Span, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && value[55] != '-') {
+		return nil, false
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return nil, false
+	}
+	version, err := hex.DecodeString(value[0:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(value) != 55) {
+		return nil, false
+	}
+
+	var span Span
+	if _, err := hex.Decode(span.TraceID[:], []byte(value[3:35])); err != nil || !span.TraceID.IsValid() {
+		return nil, false
+	}
+	if _, err := hex.Decode(span.SpanID[:], []byte(value[36:52])); err != nil || !span.SpanID.IsValid() {
+		return nil, false
+	}
+	flags, err := hex.DecodeString(value[53:55])
+	if err != nil || strings.ToLower(value[3:55]) != value[3:55] {
+		return nil, false
+	}
+	span.Sampled = flags[0]&1 == 1
+	return &span, true
+}
+
+// parseTracestate joins the tracestate header lines, dropping empty and
+// malformed members and keeping at most 32.
+func parseTracestate(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if k, _, ok := strings.Cut(m, "="); !ok || k == "" {
+				continue
+			}
+			if len(members) == 32 {
+				break
+			}
+			members = append(members, m)
+		}
+	}
+	return strings.Join(members, ",")
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
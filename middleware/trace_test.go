@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carbocation/interpose"
+)
+
+func Test_Trace(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	var outgoing http.Header
+
+	i := interpose.New()
+	i.Use(Trace(TraceOptions{Exporter: exporter}))
+	i.Use(TraceLayer("json", Json()))
+	i.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SetRoute(req, "/users/{id}")
+		outgoing = http.Header{}
+		InjectTraceContext(req.Context(), outgoing)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	r, _ := http.NewRequest("GET", "/users/7", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	i.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, wanted 2", len(spans))
+	}
+	layer, server := spans[0], spans[1]
+
+	if server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentID.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span did not continue trace: %s %s", server.TraceID, server.ParentID)
+	}
+	if server.Name != "GET /users/{id}" || server.Attributes()["http.response.status_code"] != 418 {
+		t.Errorf("unexpected server span %q %v", server.Name, server.Attributes())
+	}
+	if layer.Name != "json" || layer.TraceID != server.TraceID || layer.ParentID != server.SpanID {
+		t.Errorf("layer span not a child of server span: %+v", layer)
+	}
+	if got := outgoing.Get("traceparent"); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+layer.SpanID.String()+"-01" {
+		t.Errorf("unexpected outgoing traceparent %q", got)
+	}
+	if got := outgoing.Get("tracestate"); got != "congo=t61rcWkgMzE" {
+		t.Errorf("unexpected outgoing tracestate %q", got)
+	}
+}
+
+func Test_parseTraceparent(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00":       true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":       false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":       false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":       false,
+		"": false,
+	} {
+		if _, ok := parseTraceparent(value); ok != valid {
+			t.Errorf("parseTraceparent(%q) = %v, wanted %v", value, ok, valid)
+		}
+	}
+}
+
+func Test_OTLPExporter(t *testing.T) {
+	var payload map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		json.Unmarshal(body, &payload)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(OTLPOptions{Endpoint: collector.URL, ServiceName: "test"})
+	h := Trace(TraceOptions{Exporter: exporter})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	r, _ := http.NewRequest("GET", "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	exporter.Close()
+
+	spans := payload["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	if len(spans) != 1 || spans[0].(map[string]any)["name"] != "GET" {
+		t.Errorf("unexpected payload %v", payload)
+	}
+}